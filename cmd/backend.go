@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	containerdclient "github.com/containerd/containerd"
+	"github.com/gemalto/helm-image/internal/containerd"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+const (
+	backendLocal    = "local"
+	backendEmbedded = "embedded"
+)
+
+var backends = []string{backendLocal, backendEmbedded}
+
+func checkBackend(backend string) error {
+	for _, b := range backends {
+		if backend == b {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid backend %q, shall be one of %s", backend, strings.Join(backends, ", "))
+}
+
+// defaultContainerdNamespace returns the containerd namespace given by the CONTAINERD_NAMESPACE envvar, like for ctr,
+// the default one otherwise
+func defaultContainerdNamespace() string {
+	namespace := os.Getenv("CONTAINERD_NAMESPACE")
+	if len(namespace) > 0 {
+		return namespace
+	}
+	return "default"
+}
+
+// openBackend returns a containerd client of the given backend, either working in-process on the local cache,
+// or connected to an embedded containerd server started for the occasion, and the function releasing it. When the
+// address of an existing containerd server is given, the client is connected to it instead, whatever the backend
+func openBackend(backend string, address string, debug bool) (*containerdclient.Client, func(), error) {
+	err := checkBackend(backend)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(address) > 0 {
+		return containerd.ClientWithAddress(address, debug)
+	}
+	if backend == backendLocal {
+		return containerd.LocalClient(debug)
+	}
+	serverStarted := make(chan bool)
+	serverKill := make(chan bool)
+	serverKilled := make(chan bool)
+	go containerd.Server(serverStarted, serverKill, serverKilled, debug)
+	if !<-serverStarted {
+		return nil, nil, fmt.Errorf("cannot start containerd server")
+	}
+	stopServer := func() {
+		if debug {
+			log.Println("Sending interrupt signal to containerd server...")
+		}
+		serverKill <- true
+		<-serverKilled
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		stopServer()
+		os.Exit(1)
+	}()
+	client, closeClient, err := containerd.Client(debug)
+	if err != nil {
+		stopServer()
+		return nil, nil, err
+	}
+	return client, func() {
+		closeClient()
+		signal.Stop(interrupt)
+		stopServer()
+	}, nil
+}
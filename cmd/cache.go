@@ -7,16 +7,16 @@ import (
 	"github.com/gemalto/helm-image/internal/containerd"
 	"github.com/spf13/cobra"
 	"io"
-	"log"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
 )
 
 type cacheCmd struct {
-	debug   bool
-	verbose bool
+	backend             string
+	containerdAddress   string
+	containerdNamespace string
+	debug               bool
+	verbose             bool
 }
 
 func newCacheListCmd(out io.Writer, c *cacheCmd) *cobra.Command {
@@ -58,6 +58,9 @@ func newCacheCmd(out io.Writer) *cobra.Command {
 		newCacheCleanCmd(out, c),
 	)
 
+	cmd.PersistentFlags().StringVar(&c.backend, "backend", backendLocal, "backend reading the local cache, one of local (in-process) or embedded (containerd server shipped with the plugin)")
+	cmd.PersistentFlags().StringVar(&c.containerdAddress, "containerd-address", os.Getenv("CONTAINERD_ADDRESS"), "address of an existing containerd server to use as cache, e.g. /run/containerd/containerd.sock, no server being started if set")
+	cmd.PersistentFlags().StringVar(&c.containerdNamespace, "containerd-namespace", defaultContainerdNamespace(), "containerd namespace of the images, e.g. k8s.io for kubernetes or moby for docker")
 	cmd.PersistentFlags().BoolVarP(&c.verbose, "verbose", "v", false, "enable verbose output")
 
 	// When called through helm, debug mode is transmitted through the HELM_DEBUG envvar
@@ -70,50 +73,18 @@ func newCacheCmd(out io.Writer) *cobra.Command {
 }
 
 func (c *cacheCmd) list() error {
-	serverStarted := make(chan bool)
-	serverKill := make(chan bool)
-	serverKilled := make(chan bool)
-	go containerd.Server(serverStarted, serverKill, serverKilled, c.debug)
-	if !<-serverStarted {
-		return fmt.Errorf("cannot start containerd server")
-	}
-	interrupt := make(chan os.Signal)
-	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-interrupt
-		if c.debug {
-			log.Println("Sending interrupt signal to containerd server...")
-		}
-		serverKill <- true
-		<-serverKilled
-	}()
-	client, err := containerd.Client(c.debug)
-	if err != nil {
-		if c.debug {
-			log.Println("Sending interrupt signal to containerd server...")
-		}
-		serverKill <- true
-		<-serverKilled
-		return err
-	}
-	ctx := namespaces.WithNamespace(context.Background(), "default")
-	err = containerd.ListImages(ctx, client)
+	client, closeBackend, err := openBackend(c.backend, c.containerdAddress, c.debug)
 	if err != nil {
-		if c.debug {
-			log.Println("Sending interrupt signal to containerd server...")
-		}
-		serverKill <- true
-		<-serverKilled
 		return err
 	}
-	if c.debug {
-		log.Println("Sending interrupt signal to containerd server...")
-	}
-	serverKill <- true
-	<-serverKilled
-	return nil
+	defer closeBackend()
+	ctx := namespaces.WithNamespace(context.Background(), c.containerdNamespace)
+	return containerd.ListImages(ctx, client)
 }
 
 func (c *cacheCmd) clean() error {
+	if len(c.containerdAddress) > 0 {
+		return fmt.Errorf("cannot clean the cache of an existing containerd server, please remove its images with its own tools")
+	}
 	return containerd.DeleteContainerdDirectories()
 }
@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/gemalto/helm-image/internal/containerd"
+	"github.com/gemalto/helm-image/internal/registry"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/action"
+	cliValues "helm.sh/helm/v3/pkg/cli/values"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	imagePresent  = "present"
+	imageMissing  = "missing"
+	imageMismatch = "mismatch"
+)
+
+type checkedImage struct {
+	Image        string        `json:"image"`
+	MirrorImage  string        `json:"mirrorImage"`
+	Status       string        `json:"status"`
+	Digest       string        `json:"digest,omitempty"`
+	PinnedDigest string        `json:"pinnedDigest,omitempty"`
+	Sources      []imageSource `json:"sources,omitempty"`
+}
+
+type checkCmd struct {
+	chartName     string
+	chartPathOpts action.ChartPathOptions
+	devel         bool
+	namespace     string
+	registryName  string
+	pathMappings  []string
+	plainHTTP     bool
+	lockFile      string
+	excludes      []string
+	auths         []string
+	valuesOpts    cliValues.Options
+	allSubcharts  bool
+	rulesFiles    []string
+	heuristic     bool
+	showSources   bool
+	output        string
+	verbose       bool
+	debug         bool
+}
+
+func newCheckCmd(out io.Writer) *cobra.Command {
+	c := &checkCmd{}
+
+	cmd := &cobra.Command{
+		Use:          "check",
+		Short:        "check that docker images referenced in a chart exist in a registry",
+		Long:         "check that docker images referenced in a chart exist in a registry",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.chartName = args[0]
+			if len(c.registryName) == 0 {
+				return fmt.Errorf("--registry is required")
+			}
+			if c.output != outputText && c.output != outputJSON {
+				return fmt.Errorf("invalid output format %q, shall be one of %s or %s", c.output, outputText, outputJSON)
+			}
+			return c.check(out)
+		},
+	}
+
+	flags := cmd.Flags()
+
+	flags.StringVar(&c.registryName, "registry", "", "registry (optionally followed by a path prefix) where images shall be found, e.g. mirror.local:5000")
+	flags.StringSliceVar(&c.pathMappings, "map", []string{}, "relocate the repositories starting with a prefix under another path of the registry, as from=to (can specify multiple)")
+	flags.BoolVar(&c.plainHTTP, "plain-http", false, "reach the registry through plain HTTP instead of HTTPS")
+	flags.StringVar(&c.lockFile, "lock", "", "check that images in the registry have the digests they are pinned to in the given lock file (see lock command)")
+	flags.StringSliceVarP(&c.auths, "auth", "a", []string{}, "specify private registries which need authentication during check")
+	flags.StringSliceVarP(&c.excludes, "exclude", "x", []string{}, "specify docker images to be excluded from check")
+	flags.StringVar(&c.chartPathOpts.Version, "version", "", "specify a version constraint for the chart version to use, latest version being used if not set")
+	flags.StringVar(&c.chartPathOpts.RepoURL, "repo", "", "chart repository url where to locate the requested chart")
+	flags.StringVar(&c.chartPathOpts.Username, "username", "", "chart repository username where to locate the requested chart")
+	flags.StringVar(&c.chartPathOpts.Password, "password", "", "chart repository password where to locate the requested chart")
+	flags.BoolVar(&c.devel, "devel", false, "use development versions, too (equivalent to version '>0.0.0-0'), ignored if --version is set")
+	flags.StringSliceVarP(&c.valuesOpts.ValueFiles, "values", "f", []string{}, "specify values in a YAML file or a URL (can specify multiple)")
+	flags.StringArrayVar(&c.valuesOpts.Values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&c.valuesOpts.StringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&c.valuesOpts.FileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	flags.BoolVar(&c.allSubcharts, "all-subcharts", false, "search all sub-charts, forcing their conditions and tags to true by groups of same weight")
+	flags.StringSliceVar(&c.rulesFiles, "rules", []string{}, "specify image extraction rules for custom resources in a YAML file (can specify multiple)")
+	flags.BoolVar(&c.heuristic, "heuristic", true, "search for containers at any depth of resources which are neither known nor matched by a rule")
+	flags.BoolVar(&c.showSources, "show-sources", false, "show the chart, template, resource and container where each image has been found")
+	flags.StringVarP(&c.output, "output", "o", outputText, "output format, one of text or json")
+	flags.BoolVarP(&c.verbose, "verbose", "v", false, "enable verbose output")
+
+	// When called through helm, debug mode is transmitted through the HELM_DEBUG envvar
+	helmDebug := os.Getenv("HELM_DEBUG")
+	if helmDebug == "1" || strings.EqualFold(helmDebug, "true") || strings.EqualFold(helmDebug, "on") {
+		c.debug = true
+	}
+
+	// When called through helm, namespace is transmitted through the HELM_NAMESPACE envvar
+	namespace := os.Getenv("HELM_NAMESPACE")
+	if len(namespace) > 0 {
+		c.namespace = namespace
+	} else {
+		c.namespace = "default"
+	}
+
+	return cmd
+}
+
+// checkImages resolves in the registry the manifest of every image, relocated under the registry name
+func (c *checkCmd) checkImages(images *imagesList, includedImages []string) ([]checkedImage, error) {
+	var lock *imagesLock
+	var err error
+	if len(c.lockFile) > 0 {
+		lock, err = readLock(c.lockFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pathMappings, err := registry.ParsePathMappings(c.pathMappings)
+	if err != nil {
+		return nil, err
+	}
+	addAuthRegistries(c.auths, c.debug)
+	resolver := containerd.NewResolver(registry.ConsoleCredentials, c.plainHTTP)
+	ctx := context.Background()
+	var checkedImages []checkedImage
+	for _, image := range includedImages {
+		imageRef, err := registry.ParseImageRef(image)
+		if err != nil {
+			return nil, err
+		}
+		mirrorRef, err := registry.MirrorImageRef(imageRef, c.registryName, pathMappings)
+		if err != nil {
+			return nil, err
+		}
+		checked := checkedImage{
+			Image:       image,
+			MirrorImage: mirrorRef.String(),
+			Sources:     images.getSources(image),
+		}
+		if c.verbose {
+			fmt.Fprintf(os.Stderr, "Checking %s...\n", checked.MirrorImage)
+		}
+		resolved, err := containerd.ResolveImage(ctx, resolver, checked.MirrorImage)
+		switch {
+		case errdefs.IsNotFound(err):
+			checked.Status = imageMissing
+		case err != nil:
+			return nil, err
+		default:
+			checked.Status = imagePresent
+			checked.Digest = resolved.Digest.String()
+			if lock != nil {
+				locked := lock.find(image)
+				if locked == nil {
+					return nil, fmt.Errorf("image %s is not pinned in %s, please update it with helm image lock", image, c.lockFile)
+				}
+				checked.PinnedDigest = locked.Digest
+				if locked.Digest != checked.Digest {
+					checked.Status = imageMismatch
+					platformImages, err := containerd.ResolvePlatforms(ctx, resolver, resolved)
+					if err != nil {
+						return nil, err
+					}
+					if locked.pinsPlatforms(resolved, platformImages) {
+						checked.Status = imagePresent
+					}
+				}
+			}
+		}
+		checkedImages = append(checkedImages, checked)
+	}
+	return checkedImages, nil
+}
+
+func writeCheckedImages(out io.Writer, checkedImages []checkedImage, output string, showSources bool) error {
+	if output == outputJSON {
+		if checkedImages == nil {
+			checkedImages = []checkedImage{}
+		}
+		data, err := json.MarshalIndent(checkedImages, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	}
+	for _, checked := range checkedImages {
+		switch checked.Status {
+		case imageMismatch:
+			fmt.Fprintf(out, "%-8s %s (%s instead of %s)\n", checked.Status, checked.MirrorImage, checked.Digest, checked.PinnedDigest)
+		default:
+			fmt.Fprintf(out, "%-8s %s\n", checked.Status, checked.MirrorImage)
+		}
+		if showSources {
+			for _, source := range checked.Sources {
+				fmt.Fprintf(out, "  %s\n", source)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *checkCmd) check(out io.Writer) error {
+	l := &listCmd{
+		chartName:     c.chartName,
+		chartPathOpts: c.chartPathOpts,
+		devel:         c.devel,
+		namespace:     c.namespace,
+		valuesOpts:    c.valuesOpts,
+		allSubcharts:  c.allSubcharts,
+		rulesFiles:    c.rulesFiles,
+		heuristic:     c.heuristic,
+		debug:         c.debug,
+		verbose:       c.verbose,
+	}
+	images, err := l.list()
+	if err != nil {
+		return err
+	}
+	checkedImages, err := c.checkImages(images, excludeImages(images.get(), c.excludes))
+	if err != nil {
+		return err
+	}
+	err = writeCheckedImages(out, checkedImages, c.output, c.showSources)
+	if err != nil {
+		return err
+	}
+	missing, mismatched := 0, 0
+	for _, checked := range checkedImages {
+		switch checked.Status {
+		case imageMissing:
+			missing++
+		case imageMismatch:
+			mismatched++
+		}
+	}
+	if missing > 0 || mismatched > 0 {
+		return fmt.Errorf("%d images missing and %d images with another digest in %s", missing, mismatched, c.registryName)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gemalto/helm-image/internal/registry"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/action"
+	cliValues "helm.sh/helm/v3/pkg/cli/values"
+	"io"
+	"os"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+type retaggedImage struct {
+	Repository string      `json:"repository"`
+	Old        imageOutput `json:"old"`
+	New        imageOutput `json:"new"`
+}
+
+// imagesDiff gathers the images added, removed or retagged (same repository, other tag) between two charts
+type imagesDiff struct {
+	Added    []imageOutput   `json:"added"`
+	Removed  []imageOutput   `json:"removed"`
+	Retagged []retaggedImage `json:"retagged"`
+}
+
+type diffCmd struct {
+	oldChartName  string
+	newChartName  string
+	chartPathOpts action.ChartPathOptions
+	oldVersion    string
+	newVersion    string
+	devel         bool
+	namespace     string
+	valuesOpts    cliValues.Options
+	oldValuesOpts cliValues.Options
+	newValuesOpts cliValues.Options
+	allSubcharts  bool
+	rulesFiles    []string
+	heuristic     bool
+	showSources   bool
+	output        string
+	verbose       bool
+	debug         bool
+}
+
+func newDiffCmd(out io.Writer) *cobra.Command {
+	d := &diffCmd{}
+
+	cmd := &cobra.Command{
+		Use:          "diff",
+		Short:        "show docker images added, removed or retagged between two charts",
+		Long:         "show docker images added, removed or retagged between two charts",
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.oldChartName = args[0]
+			d.newChartName = args[1]
+			switch d.output {
+			case outputText, outputJSON, outputYAML:
+			default:
+				return fmt.Errorf("invalid output format %q, shall be one of %s, %s or %s", d.output, outputText, outputJSON, outputYAML)
+			}
+			return d.diff(out)
+		},
+	}
+
+	flags := cmd.Flags()
+
+	flags.StringVar(&d.oldVersion, "old-version", "", "specify a version constraint for the old chart version to use, latest version being used if not set")
+	flags.StringVar(&d.newVersion, "new-version", "", "specify a version constraint for the new chart version to use, latest version being used if not set")
+	flags.StringVar(&d.chartPathOpts.RepoURL, "repo", "", "chart repository url where to locate the requested charts")
+	flags.StringVar(&d.chartPathOpts.Username, "username", "", "chart repository username where to locate the requested charts")
+	flags.StringVar(&d.chartPathOpts.Password, "password", "", "chart repository password where to locate the requested charts")
+	flags.BoolVar(&d.devel, "devel", false, "use development versions, too (equivalent to version '>0.0.0-0'), ignored if --old-version or --new-version is set")
+	flags.StringSliceVarP(&d.valuesOpts.ValueFiles, "values", "f", []string{}, "specify values of both charts in a YAML file or a URL (can specify multiple)")
+	flags.StringArrayVar(&d.valuesOpts.Values, "set", []string{}, "set values of both charts on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&d.valuesOpts.StringValues, "set-string", []string{}, "set STRING values of both charts on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&d.valuesOpts.FileValues, "set-file", []string{}, "set values of both charts from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	flags.StringSliceVar(&d.oldValuesOpts.ValueFiles, "old-values", []string{}, "specify values of the old chart in a YAML file or a URL (can specify multiple)")
+	flags.StringArrayVar(&d.oldValuesOpts.Values, "old-set", []string{}, "set values of the old chart on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&d.oldValuesOpts.StringValues, "old-set-string", []string{}, "set STRING values of the old chart on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&d.oldValuesOpts.FileValues, "old-set-file", []string{}, "set values of the old chart from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	flags.StringSliceVar(&d.newValuesOpts.ValueFiles, "new-values", []string{}, "specify values of the new chart in a YAML file or a URL (can specify multiple)")
+	flags.StringArrayVar(&d.newValuesOpts.Values, "new-set", []string{}, "set values of the new chart on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&d.newValuesOpts.StringValues, "new-set-string", []string{}, "set STRING values of the new chart on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&d.newValuesOpts.FileValues, "new-set-file", []string{}, "set values of the new chart from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	flags.BoolVar(&d.allSubcharts, "all-subcharts", false, "search all sub-charts, forcing their conditions and tags to true by groups of same weight")
+	flags.StringSliceVar(&d.rulesFiles, "rules", []string{}, "specify image extraction rules for custom resources in a YAML file (can specify multiple)")
+	flags.BoolVar(&d.heuristic, "heuristic", true, "search for containers at any depth of resources which are neither known nor matched by a rule")
+	flags.BoolVar(&d.showSources, "show-sources", false, "show the chart, template, resource and container where each image has been found")
+	flags.StringVarP(&d.output, "output", "o", outputText, "output format, one of text, json or yaml")
+	flags.BoolVarP(&d.verbose, "verbose", "v", false, "enable verbose output")
+
+	// When called through helm, debug mode is transmitted through the HELM_DEBUG envvar
+	helmDebug := os.Getenv("HELM_DEBUG")
+	if helmDebug == "1" || strings.EqualFold(helmDebug, "true") || strings.EqualFold(helmDebug, "on") {
+		d.debug = true
+	}
+
+	// When called through helm, namespace is transmitted through the HELM_NAMESPACE envvar
+	namespace := os.Getenv("HELM_NAMESPACE")
+	if len(namespace) > 0 {
+		d.namespace = namespace
+	} else {
+		d.namespace = "default"
+	}
+
+	return cmd
+}
+
+func (d *diffCmd) listImages(chartName string, version string, valuesOpts cliValues.Options) ([]imageOutput, error) {
+	chartPathOpts := d.chartPathOpts
+	chartPathOpts.Version = version
+	mergedValuesOpts := d.valuesOpts
+	mergedValuesOpts.ValueFiles = append(append([]string{}, d.valuesOpts.ValueFiles...), valuesOpts.ValueFiles...)
+	mergedValuesOpts.Values = append(append([]string{}, d.valuesOpts.Values...), valuesOpts.Values...)
+	mergedValuesOpts.StringValues = append(append([]string{}, d.valuesOpts.StringValues...), valuesOpts.StringValues...)
+	mergedValuesOpts.FileValues = append(append([]string{}, d.valuesOpts.FileValues...), valuesOpts.FileValues...)
+	l := &listCmd{
+		chartName:     chartName,
+		chartPathOpts: chartPathOpts,
+		devel:         d.devel,
+		namespace:     d.namespace,
+		valuesOpts:    mergedValuesOpts,
+		allSubcharts:  d.allSubcharts,
+		rulesFiles:    d.rulesFiles,
+		heuristic:     d.heuristic,
+		debug:         d.debug,
+		verbose:       d.verbose,
+	}
+	images, err := l.list()
+	if err != nil {
+		return nil, err
+	}
+	return newImagesOutput(images), nil
+}
+
+func imageRepository(image string) string {
+	ref, err := registry.ParseImageRef(image)
+	if err != nil {
+		return image
+	}
+	return ref.Name()
+}
+
+// diffImages compares two lists of images on their normalized references, images of a same repository
+// being paired as retagged in alphabetical order
+func diffImages(oldImages []imageOutput, newImages []imageOutput) *imagesDiff {
+	oldNames := map[string]struct{}{}
+	for _, image := range oldImages {
+		oldNames[normalizedImage(image.Image)] = struct{}{}
+	}
+	newNames := map[string]struct{}{}
+	for _, image := range newImages {
+		newNames[normalizedImage(image.Image)] = struct{}{}
+	}
+	removedByRepository := map[string][]imageOutput{}
+	for _, image := range oldImages {
+		if _, ok := newNames[normalizedImage(image.Image)]; !ok {
+			repository := imageRepository(image.Image)
+			removedByRepository[repository] = append(removedByRepository[repository], image)
+		}
+	}
+	diff := &imagesDiff{
+		Added:    []imageOutput{},
+		Removed:  []imageOutput{},
+		Retagged: []retaggedImage{},
+	}
+	for _, image := range newImages {
+		if _, ok := oldNames[normalizedImage(image.Image)]; ok {
+			continue
+		}
+		repository := imageRepository(image.Image)
+		if removed := removedByRepository[repository]; len(removed) > 0 {
+			diff.Retagged = append(diff.Retagged, retaggedImage{
+				Repository: repository,
+				Old:        removed[0],
+				New:        image,
+			})
+			removedByRepository[repository] = removed[1:]
+		} else {
+			diff.Added = append(diff.Added, image)
+		}
+	}
+	for _, removed := range removedByRepository {
+		diff.Removed = append(diff.Removed, removed...)
+	}
+	sort.Slice(diff.Removed, func(i, j int) bool {
+		return diff.Removed[i].Image < diff.Removed[j].Image
+	})
+	return diff
+}
+
+func writeDiffSources(out io.Writer, sources []imageSource) {
+	for _, source := range sources {
+		fmt.Fprintf(out, "    %s\n", source)
+	}
+}
+
+func writeDiff(out io.Writer, diff *imagesDiff, output string, showSources bool) error {
+	switch output {
+	case outputJSON:
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case outputYAML:
+		data, err := yaml.Marshal(diff)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	}
+	for _, image := range diff.Added {
+		fmt.Fprintf(out, "+ %s\n", image.Image)
+		if showSources {
+			writeDiffSources(out, image.Sources)
+		}
+	}
+	for _, image := range diff.Removed {
+		fmt.Fprintf(out, "- %s\n", image.Image)
+		if showSources {
+			writeDiffSources(out, image.Sources)
+		}
+	}
+	for _, image := range diff.Retagged {
+		fmt.Fprintf(out, "~ %s -> %s\n", image.Old.Image, image.New.Image)
+		if showSources {
+			writeDiffSources(out, image.New.Sources)
+		}
+	}
+	return nil
+}
+
+func (d *diffCmd) diff(out io.Writer) error {
+	oldImages, err := d.listImages(d.oldChartName, d.oldVersion, d.oldValuesOpts)
+	if err != nil {
+		return err
+	}
+	newImages, err := d.listImages(d.newChartName, d.newVersion, d.newValuesOpts)
+	if err != nil {
+		return err
+	}
+	return writeDiff(out, diffImages(oldImages, newImages), d.output, d.showSources)
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDiffImages(t *testing.T) {
+	tests := []struct {
+		name      string
+		oldImages []string
+		newImages []string
+		expected  string
+	}{
+		{
+			name:      "same images",
+			oldImages: []string{"busybox:1.36", "nginx:1.25"},
+			newImages: []string{"busybox:1.36", "nginx:1.25"},
+		},
+		{
+			name:      "same normalized images",
+			oldImages: []string{"nginx:1.25"},
+			newImages: []string{"docker.io/library/nginx:1.25"},
+		},
+		{
+			name:      "added and removed images",
+			oldImages: []string{"busybox:1.36", "nginx:1.25", "alpine:3.18"},
+			newImages: []string{"nginx:1.25", "quay.io/prometheus/prometheus:v2.45.0"},
+			expected: `+ quay.io/prometheus/prometheus:v2.45.0
+- alpine:3.18
+- busybox:1.36
+`,
+		},
+		{
+			name:      "retagged image",
+			oldImages: []string{"busybox:1.36", "nginx:1.24"},
+			newImages: []string{"busybox:1.36", "docker.io/library/nginx:1.25"},
+			expected: `~ nginx:1.24 -> docker.io/library/nginx:1.25
+`,
+		},
+		{
+			name:      "retagged images paired in order",
+			oldImages: []string{"envoy:v1.25", "nginx:1.23", "nginx:1.24"},
+			newImages: []string{"envoy:v1.26", "envoy:v1.27", "nginx:1.25"},
+			expected: `+ envoy:v1.27
+- nginx:1.24
+~ envoy:v1.25 -> envoy:v1.26
+~ nginx:1.23 -> nginx:1.25
+`,
+		},
+		{
+			name:      "digest retagged",
+			oldImages: []string{"nginx:1.25"},
+			newImages: []string{"nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+			expected: `~ nginx:1.25 -> nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+`,
+		},
+	}
+	images := func(names []string) []imageOutput {
+		var images []imageOutput
+		for _, name := range names {
+			images = append(images, imageOutput{Image: name})
+		}
+		return images
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := writeDiff(&out, diffImages(images(test.oldImages), images(test.newImages)), outputText, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestWriteDiffJSON(t *testing.T) {
+	var out bytes.Buffer
+	err := writeDiff(&out, diffImages(nil, nil), outputJSON, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "added": [],
+  "removed": [],
+  "retagged": []
+}
+`
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
@@ -1,56 +1,277 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"github.com/docker/distribution/reference"
+	"github.com/gemalto/helm-image/internal/containerd"
+	"github.com/gemalto/helm-image/internal/credentials"
 	"github.com/gemalto/helm-image/internal/helm"
+	"github.com/gemalto/helm-image/internal/registry"
+	"github.com/gemalto/helm-image/internal/rules"
 	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	cliValues "helm.sh/helm/v3/pkg/cli/values"
 	"io"
-	"io/ioutil"
-	appsv1 "k8s.io/api/apps/v1"
-	batchv1 "k8s.io/api/batch/v1"
-	"k8s.io/client-go/kubernetes/scheme"
 	"log"
 	"os"
-	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+// imageSource describes where an image has been found
+type imageSource struct {
+	Profile   string `json:"profile,omitempty"`
+	Chart     string `json:"chart"`
+	Template  string `json:"template"`
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Container string `json:"container,omitempty"`
+	Init      bool   `json:"init,omitempty"`
+	Ephemeral bool   `json:"ephemeral,omitempty"`
+	Heuristic bool   `json:"heuristic,omitempty"`
+}
+
+func (s imageSource) String() string {
+	var b strings.Builder
+	b.WriteString(s.Template)
+	if len(s.Kind) > 0 {
+		b.WriteString(": " + s.Kind + " ")
+		if len(s.Namespace) > 0 {
+			b.WriteString(s.Namespace + "/")
+		}
+		b.WriteString(s.Name)
+	}
+	if len(s.Container) > 0 {
+		switch {
+		case s.Init:
+			b.WriteString(", init container ")
+		case s.Ephemeral:
+			b.WriteString(", ephemeral container ")
+		default:
+			b.WriteString(", container ")
+		}
+		b.WriteString(s.Container)
+	}
+	if len(s.Profile) > 0 {
+		b.WriteString(", profile " + s.Profile)
+	}
+	if s.Heuristic {
+		b.WriteString(" (heuristic)")
+	}
+	return b.String()
+}
+
+const (
+	refStyleFull       = "full"
+	refStyleFamiliar   = "familiar"
+	refStyleAsRendered = "as-rendered"
+)
+
+type imageInfo struct {
+	ref      reference.Named
+	rendered []string
+	sources  []imageSource
+	resolved *containerd.ResolvedImage
+}
+
+// name returns the image reference in the requested style, the first rendered one in alphabetical order being used as-rendered
+func (i *imageInfo) name(refStyle string) string {
+	switch refStyle {
+	case refStyleFull:
+		return i.ref.String()
+	case refStyleFamiliar:
+		return reference.FamiliarString(i.ref)
+	default:
+		rendered := append([]string{}, i.rendered...)
+		sort.Strings(rendered)
+		return rendered[0]
+	}
+}
+
+func addSource(sources []imageSource, source imageSource) []imageSource {
+	for _, s := range sources {
+		if s == source {
+			return sources
+		}
+	}
+	return append(sources, source)
+}
+
+// imagesList gathers the images found in a chart, deduplicated on their normalized reference
 type imagesList struct {
-	images map[string]struct{}
-	mu     sync.Mutex
+	images   map[string]*imageInfo
+	invalid  map[string]*imageInfo
+	refStyle string
+	streams  *imageStreams
+	mu       sync.Mutex
 }
 
-func newImagesList() *imagesList {
+func newImagesList(refStyle string) *imagesList {
 	return &imagesList{
-		images: map[string]struct{}{},
+		images:   map[string]*imageInfo{},
+		invalid:  map[string]*imageInfo{},
+		refStyle: refStyle,
+		streams:  newImageStreams(),
 	}
 }
 
-func (l *imagesList) add(image string) {
+func (l *imagesList) add(image string, source imageSource) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	l.images[image] = struct{}{}
+	ref, err := registry.ParseImageRef(image)
+	if err != nil {
+		info, ok := l.invalid[image]
+		if !ok {
+			info = &imageInfo{}
+			l.invalid[image] = info
+		}
+		info.sources = addSource(info.sources, source)
+		return
+	}
+	info, ok := l.images[ref.String()]
+	if !ok {
+		info = &imageInfo{
+			ref: ref,
+		}
+		l.images[ref.String()] = info
+	}
+	found := false
+	for _, rendered := range info.rendered {
+		if rendered == image {
+			found = true
+		}
+	}
+	if !found {
+		info.rendered = append(info.rendered, image)
+	}
+	info.sources = addSource(info.sources, source)
+}
+
+// lookup returns the information of an image whatever the style of its reference
+func (l *imagesList) lookup(image string) (*imageInfo, bool) {
+	ref, err := registry.ParseImageRef(image)
+	if err != nil {
+		info, ok := l.invalid[image]
+		return info, ok
+	}
+	info, ok := l.images[ref.String()]
+	return info, ok
 }
 
 func (l *imagesList) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	var images []string
-	for image, _ := range l.images {
-		images = append(images, image)
+	for _, info := range l.images {
+		images = append(images, info.name(l.refStyle))
 	}
+	sort.Strings(images)
 	return images
 }
 
 func (l *imagesList) contains(image string) bool {
-	if _, ok := l.images[image]; ok {
-		return true
-	} else {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.lookup(image)
+	return ok
+}
+
+// isHeuristic returns true when an image has only been found by searching heuristically for containers
+func (l *imagesList) isHeuristic(image string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	info, ok := l.lookup(image)
+	if !ok {
 		return false
 	}
+	for _, source := range info.sources {
+		if !source.Heuristic {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *imagesList) getSources(image string) []imageSource {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if info, ok := l.lookup(image); ok {
+		sources := append([]imageSource{}, info.sources...)
+		sort.Slice(sources, func(i, j int) bool {
+			return sources[i].String() < sources[j].String()
+		})
+		return sources
+	}
+	return nil
+}
+
+func (l *imagesList) setResolved(image string, resolved *containerd.ResolvedImage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if info, ok := l.lookup(image); ok {
+		info.resolved = resolved
+	}
+}
+
+// getResolved returns the manifest the image has been resolved to, or nil when digests have not been resolved
+func (l *imagesList) getResolved(image string) *containerd.ResolvedImage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if info, ok := l.lookup(image); ok {
+		return info.resolved
+	}
+	return nil
+}
+
+// getInvalid returns the image references which cannot be parsed, with where they come from
+func (l *imagesList) getInvalid() map[string][]imageSource {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	invalid := map[string][]imageSource{}
+	for image, info := range l.invalid {
+		invalid[image] = append([]imageSource{}, info.sources...)
+	}
+	return invalid
+}
+
+func normalizedImage(image string) string {
+	ref, err := registry.ParseImageRef(image)
+	if err != nil {
+		return image
+	}
+	return ref.String()
+}
+
+// excludeImages returns the images not matching any of the excluded ones, whatever the style of their references
+func excludeImages(images []string, excludes []string) []string {
+	excluded := map[string]struct{}{}
+	for _, image := range excludes {
+		excluded[normalizedImage(image)] = struct{}{}
+	}
+	var includedImages []string
+	for _, image := range images {
+		if _, ok := excluded[normalizedImage(image)]; !ok {
+			includedImages = append(includedImages, image)
+		}
+	}
+	return includedImages
+}
+
+func addAuthRegistries(auths []string, debug bool) {
+	for _, auth := range auths {
+		login, password, err := credentials.GetAuth(auth)
+		if err != nil && debug {
+			log.Printf("Warning: cannot get authentication information: %s\n", err)
+		}
+		registry.AddAuthRegistry(auth, login, password)
+	}
 }
 
 type taskErrors struct {
@@ -75,22 +296,38 @@ func (e *taskErrors) get() []error {
 }
 
 type processChartInfo struct {
-	images    *imagesList
-	chartName string
-	valuesSet []string
+	images     *imagesList
+	chartName  string
+	valuesOpts cliValues.Options
+	profile    string
 }
 
 type listCmd struct {
-	chartName  string
-	namespace  string
-	valuesOpts cliValues.Options
-	helmPath   string
-	verbose    bool
-	debug      bool
+	chartName      string
+	chartPathOpts  action.ChartPathOptions
+	devel          bool
+	chartPath      string
+	namespace      string
+	valuesOpts     cliValues.Options
+	allSubcharts   bool
+	profiles       []profile
+	rulesFiles     []string
+	rules          *rules.Rules
+	heuristic      bool
+	showSources    bool
+	refStyle       string
+	resolveDigests bool
+	auths          []string
+	output         string
+	format         string
+	verbose        bool
+	debug          bool
 }
 
 func newListCmd(out io.Writer) *cobra.Command {
 	l := &listCmd{}
+	var profiles []string
+	var profilesFile string
 
 	cmd := &cobra.Command{
 		Use:          "list",
@@ -100,28 +337,52 @@ func newListCmd(out io.Writer) *cobra.Command {
 		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			l.chartName = args[0]
+			err := checkOutputFormat(l.output, l.format)
+			if err != nil {
+				return err
+			}
+			l.profiles, err = parseProfiles(profiles, profilesFile)
+			if err != nil {
+				return err
+			}
 			images, err := l.list()
 			if err != nil {
 				return err
 			}
-			for _, image := range images {
-				fmt.Println(image)
+			if l.resolveDigests {
+				err = l.resolveImageDigests(images)
+				if err != nil {
+					return err
+				}
 			}
-			return nil
+			return writeImages(out, images, l.output, l.format, l.showSources)
 		},
 	}
 
 	flags := cmd.Flags()
 
+	flags.StringVar(&l.chartPathOpts.Version, "version", "", "specify a version constraint for the chart version to use, latest version being used if not set")
+	flags.StringVar(&l.chartPathOpts.RepoURL, "repo", "", "chart repository url where to locate the requested chart")
+	flags.StringVar(&l.chartPathOpts.Username, "username", "", "chart repository username where to locate the requested chart")
+	flags.StringVar(&l.chartPathOpts.Password, "password", "", "chart repository password where to locate the requested chart")
+	flags.BoolVar(&l.devel, "devel", false, "use development versions, too (equivalent to version '>0.0.0-0'), ignored if --version is set")
 	flags.StringSliceVarP(&l.valuesOpts.ValueFiles, "values", "f", []string{}, "specify values in a YAML file or a URL (can specify multiple)")
 	flags.StringArrayVar(&l.valuesOpts.Values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
 	flags.StringArrayVar(&l.valuesOpts.StringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
 	flags.StringArrayVar(&l.valuesOpts.FileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	flags.BoolVar(&l.allSubcharts, "all-subcharts", false, "search all sub-charts, forcing their conditions and tags to true by groups of same weight")
+	flags.StringSliceVar(&profiles, "profile", []string{}, "render the chart for a named profile of values files, as name=file (can specify multiple)")
+	flags.StringVar(&profilesFile, "profiles-file", "", "render the chart for each profile of values files defined in a YAML file")
+	flags.StringSliceVar(&l.rulesFiles, "rules", []string{}, "specify image extraction rules for custom resources in a YAML file (can specify multiple)")
+	flags.BoolVar(&l.heuristic, "heuristic", true, "search for containers at any depth of resources which are neither known nor matched by a rule")
+	flags.BoolVar(&l.showSources, "show-sources", false, "show the chart, template, resource and container where each image has been found")
+	flags.StringVar(&l.refStyle, "ref-style", refStyleAsRendered, "style of image references, one of full (docker.io/library/nginx:latest), familiar (nginx:latest) or as-rendered")
+	flags.BoolVar(&l.resolveDigests, "resolve-digests", false, "query registries for the digest and media type each image tag points to")
+	flags.StringSliceVarP(&l.auths, "auth", "a", []string{}, "specify private registries which need authentication when resolving digests")
+	flags.StringVarP(&l.output, "output", "o", outputText, "output format, one of text, json, yaml, csv or table")
+	flags.StringVar(&l.format, "format", "", "format each image with a Go template (fields: .Image, .Heuristic, .Profiles, .Sources, .Digest, .MediaType, .Index)")
 	flags.BoolVarP(&l.verbose, "verbose", "v", false, "enable verbose output")
 
-	// When called through helm, helm path is transmitted through the HELM_BIN envvar
-	l.helmPath = os.Getenv("HELM_BIN")
-
 	// When called through helm, debug mode is transmitted through the HELM_DEBUG envvar
 	helmDebug := os.Getenv("HELM_DEBUG")
 	if helmDebug == "1" || strings.EqualFold(helmDebug, "true") || strings.EqualFold(helmDebug, "on") {
@@ -151,199 +412,72 @@ func duration(d time.Duration) string {
 	return s
 }
 
-func removeTempDir(tempDir string) {
-	if err := os.RemoveAll(tempDir); err != nil {
-		log.Printf("Warning: removing temporary directory: %s\n", err)
-	}
-}
-
-func addContainerImages(images *imagesList, path string, verbose bool, debug bool) error {
-	if debug {
-		log.Printf("Parsing %s...\n", path)
+func (l *listCmd) processChart(images *imagesList, valuesOpts cliValues.Options, profile string) error {
+	if l.verbose {
+		if len(profile) > 0 {
+			fmt.Fprintf(os.Stderr, "Rendering %s for profile %s with %v...\n", l.chartName, profile, valuesOpts.Values)
+		} else {
+			fmt.Fprintf(os.Stderr, "Rendering %s with %v...\n", l.chartName, valuesOpts.Values)
+		}
 	}
-	content, err := ioutil.ReadFile(path)
+	manifests, err := helm.Template(l.chartPath, l.namespace, &valuesOpts, l.debug)
 	if err != nil {
 		return err
 	}
-	manifest, _, err := scheme.Codecs.UniversalDeserializer().Decode(content, nil, nil)
-	if err != nil {
-		if debug {
-			log.Printf("Warning: cannot parse %s: %s\n", path, err)
-		}
-		return nil
+	var names []string
+	for name := range manifests {
+		names = append(names, name)
 	}
-	deployment, ok := manifest.(*appsv1.Deployment)
-	if ok {
-		if debug {
-			log.Printf("Searching for deployment images in %s...\n", path)
-		}
-		for _, container := range deployment.Spec.Template.Spec.Containers {
-			if !images.contains(container.Image) {
-				if verbose {
-					fmt.Printf("Found %s\n", container.Image)
-				}
-				images.add(container.Image)
-			} else if debug {
-				fmt.Printf("Ignoring %s\n", container.Image)
-			}
+	sort.Strings(names)
+	for _, name := range names {
+		if l.debug {
+			log.Printf("Parsing %s...\n", name)
 		}
-		for _, container := range deployment.Spec.Template.Spec.InitContainers {
-			if !images.contains(container.Image) {
-				if verbose {
-					fmt.Printf("Found %s\n", container.Image)
-				}
-				images.add(container.Image)
-			} else if debug {
-				fmt.Printf("Ignoring %s\n", container.Image)
-			}
-		}
-	}
-	statefulSet, ok := manifest.(*appsv1.StatefulSet)
-	if ok {
-		if debug {
-			log.Printf("Searching for statefulset images in %s...\n", path)
-		}
-		for _, container := range statefulSet.Spec.Template.Spec.Containers {
-			if !images.contains(container.Image) {
-				if verbose {
-					fmt.Printf("Found %s\n", container.Image)
-				}
-				images.add(container.Image)
-			} else if debug {
-				fmt.Printf("Ignoring %s\n", container.Image)
-			}
-		}
-		for _, container := range statefulSet.Spec.Template.Spec.InitContainers {
-			if !images.contains(container.Image) {
-				if verbose {
-					fmt.Printf("Found %s\n", container.Image)
-				}
-				images.add(container.Image)
-			} else if debug {
-				fmt.Printf("Ignoring %s\n", container.Image)
-			}
-		}
-	}
-	jobs, ok := manifest.(*batchv1.Job)
-	if ok {
-		if debug {
-			log.Printf("Searching for job images in %s...\n", path)
-		}
-		for _, container := range jobs.Spec.Template.Spec.Containers {
-			if !images.contains(container.Image) {
-				if verbose {
-					fmt.Printf("Found %s\n", container.Image)
-				}
-				images.add(container.Image)
-			} else if debug {
-				fmt.Printf("Ignoring %s\n", container.Image)
-			}
-		}
-		for _, container := range jobs.Spec.Template.Spec.InitContainers {
-			if !images.contains(container.Image) {
-				if verbose {
-					fmt.Printf("Found %s\n", container.Image)
-				}
-				images.add(container.Image)
-			} else if debug {
-				fmt.Printf("Ignoring %s\n", container.Image)
-			}
-		}
-	}
-	cronJobs, ok := manifest.(*batchv1.CronJob)
-	if ok {
-		if debug {
-			log.Printf("Searching for cron job images in %s...\n", path)
-		}
-		for _, container := range cronJobs.Spec.JobTemplate.Spec.Template.Spec.Containers {
-			if !images.contains(container.Image) {
-				if verbose {
-					fmt.Printf("Found %s\n", container.Image)
-				}
-				images.add(container.Image)
-			} else if debug {
-				fmt.Printf("Ignoring %s\n", container.Image)
-			}
-		}
-		for _, container := range cronJobs.Spec.JobTemplate.Spec.Template.Spec.InitContainers {
-			if !images.contains(container.Image) {
-				if verbose {
-					fmt.Printf("Found %s\n", container.Image)
-				}
-				images.add(container.Image)
-			} else if debug {
-				fmt.Printf("Ignoring %s\n", container.Image)
-			}
-		}
-	}
-	return nil
-}
-
-func parseManifests(images *imagesList, path string, chartName string, verbose bool, debug bool) error {
-	err := filepath.Walk(filepath.Join(path, chartName), func(path string, info os.FileInfo, err error) error {
-		if strings.HasSuffix(path, ".yaml") {
-			err = addContainerImages(images, path, verbose, debug)
-			if err != nil {
-				return err
-			}
+		err = l.addManifestImages(images, name, []byte(manifests[name]), profile)
+		if err != nil {
+			return err
 		}
-		return nil
-	})
-	if err != nil {
-		return err
 	}
 	return nil
 }
 
-func (l *listCmd) processChart(images *imagesList, chartName string, valuesSet []string) error {
-	tempDir, err := ioutil.TempDir("", "helm-image-")
-	if err != nil {
-		return fmt.Errorf("creating temporary directory to write rendered manifests: %w", err)
+// forceDependency returns the values enabling a sub-chart whatever its condition and tags
+func forceDependency(dep helm.Dependency) string {
+	valuesSet := dep.Name + ".enabled=true,"
+	for _, condition := range dep.Conditions {
+		valuesSet = valuesSet + condition + "=true,"
 	}
-	defer removeTempDir(tempDir)
-	if l.verbose {
-		fmt.Printf("Rendering %s with %v...\n", l.chartName, valuesSet)
+	for _, tag := range dep.Tags {
+		valuesSet = valuesSet + "tags." + tag + "=true,"
 	}
-	err = helm.Template(l.helmPath, tempDir, l.namespace, l.chartName, l.valuesOpts.ValueFiles, valuesSet, l.valuesOpts.StringValues, l.valuesOpts.FileValues, l.debug)
-	if err != nil {
-		return err
-	}
-	err = parseManifests(images, tempDir, chartName, l.verbose, l.debug)
-	if err != nil {
-		return err
-	}
-	return nil
+	return valuesSet
 }
 
-func (l *listCmd) list() ([]string, error) {
-	images := newImagesList()
-
-	if l.verbose {
-		fmt.Printf("Loading chart %s...\n", l.chartName)
-	}
-	// TODO manage remote charts
-	chart, err := loader.Load(l.chartName)
-	if err != nil {
-		return nil, err
+// renderChart renders the chart with the given values, and adds the images found in the rendered manifests
+func (l *listCmd) renderChart(images *imagesList, chart *chart.Chart, valuesOpts cliValues.Options, profile string) error {
+	if !l.allSubcharts {
+		// sub-charts are enabled or not by their condition and tags, just like helm does
+		return l.processChart(images, valuesOpts, profile)
 	}
 	if l.debug {
 		log.Println("Merging values...")
 	}
-	mergedValues, err := helm.MergeValues(chart, &l.valuesOpts)
+	mergedValues, err := helm.MergeValues(chart, &valuesOpts)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if l.debug {
 		log.Println("Loading dependencies...")
 	}
 	deps, err := helm.GetDependencies(chart, &mergedValues)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	start := time.Now()
-
 	if len(deps) > 0 {
+		// renderings still queued are skipped once one of them fails, so that the first error is returned
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 		var wg sync.WaitGroup
 		tasks := make(chan processChartInfo)
 		taskErrors := newTaskErrors()
@@ -352,19 +486,23 @@ func (l *listCmd) list() ([]string, error) {
 			go func(tasks chan processChartInfo, wg *sync.WaitGroup) {
 				defer wg.Done()
 				for task := range tasks {
+					if ctx.Err() != nil {
+						continue
+					}
 					if l.debug {
-						log.Printf("Starting task for %s with %v\n", task.chartName, task.valuesSet)
+						log.Printf("Starting task for %s with %v\n", task.chartName, task.valuesOpts.Values)
 					}
-					err := l.processChart(task.images, task.chartName, task.valuesSet)
+					err := l.processChart(task.images, task.valuesOpts, task.profile)
 					if err != nil {
 						taskErrors.add(err)
+						cancel()
 						if l.debug {
-							log.Printf("End with error of task for %s with %v\n", task.chartName, task.valuesSet)
+							log.Printf("End with error of task for %s with %v\n", task.chartName, task.valuesOpts.Values)
 						}
-						break
+						continue
 					}
 					if l.debug {
-						log.Printf("End without error of task for %s with %v\n", task.chartName, task.valuesSet)
+						log.Printf("End without error of task for %s with %v\n", task.chartName, task.valuesOpts.Values)
 					}
 				}
 			}(tasks, &wg)
@@ -375,21 +513,26 @@ func (l *listCmd) list() ([]string, error) {
 				maxWeight = dep.Weight
 			}
 		}
+	dispatch:
 		for w := 0; w <= maxWeight; w++ {
 			depValuesSet := ""
 			for _, dep := range deps {
 				if dep.Weight == w {
-					depValuesSet = depValuesSet + dep.Name + ".enabled=true,"
+					depValuesSet = depValuesSet + forceDependency(dep)
 				}
 			}
 			if len(depValuesSet) > 0 {
-				var valuesSet []string
-				valuesSet = append(valuesSet, l.valuesOpts.Values...)
-				valuesSet = append(valuesSet, depValuesSet)
-				tasks <- processChartInfo{
-					images:    images,
-					chartName: chart.Name(),
-					valuesSet: valuesSet,
+				depValuesOpts := valuesOpts
+				depValuesOpts.Values = append(append([]string{}, valuesOpts.Values...), depValuesSet)
+				select {
+				case tasks <- processChartInfo{
+					images:     images,
+					chartName:  chart.Name(),
+					valuesOpts: depValuesOpts,
+					profile:    profile,
+				}:
+				case <-ctx.Done():
+					break dispatch
 				}
 			}
 		}
@@ -397,22 +540,111 @@ func (l *listCmd) list() ([]string, error) {
 		wg.Wait()
 		errors := taskErrors.get()
 		if len(errors) > 0 {
-			for _, err := range errors {
+			for _, err := range errors[1:] {
 				log.Printf("Error: %s\n", err)
 			}
-			return nil, fmt.Errorf("processing one of the sub-chart")
+			return fmt.Errorf("processing sub-charts of %s: %w", chart.Name(), errors[0])
 		}
 	} else {
-		err = l.processChart(images, chart.Name(), l.valuesOpts.Values)
+		err = l.processChart(images, valuesOpts, profile)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderProfile renders the chart for a profile, and resolves the image stream tags referenced by its resources
+// against the image streams rendered for the same profile only, as each profile is installed on its own
+func (l *listCmd) renderProfile(images *imagesList, chart *chart.Chart, valuesOpts cliValues.Options, profile string) error {
+	images.streams = newImageStreams()
+	err := l.renderChart(images, chart, valuesOpts, profile)
+	if err != nil {
+		return err
+	}
+	l.resolveImageStreams(images)
+	return nil
+}
+
+func (l *listCmd) list() (*imagesList, error) {
+	switch l.refStyle {
+	case refStyleFull, refStyleFamiliar, refStyleAsRendered:
+	case "":
+		l.refStyle = refStyleAsRendered
+	default:
+		return nil, fmt.Errorf("invalid reference style %q, shall be one of %s, %s or %s", l.refStyle, refStyleFull, refStyleFamiliar, refStyleAsRendered)
+	}
+	images := newImagesList(l.refStyle)
+
+	if l.debug {
+		log.Println("Loading image extraction rules...")
+	}
+	var err error
+	l.rules, err = rules.Load(l.rulesFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.verbose {
+		fmt.Fprintf(os.Stderr, "Loading chart %s...\n", l.chartName)
+	}
+	l.chartPath, err = helm.LocateChart(l.chartName, &l.chartPathOpts, l.devel, l.debug)
+	if err != nil {
+		return nil, err
+	}
+	chart, err := loader.Load(l.chartPath)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+
+	for _, profile := range l.profiles {
+		valuesOpts := l.valuesOpts
+		valuesOpts.ValueFiles = append(append([]string{}, l.valuesOpts.ValueFiles...), profile.valueFiles...)
+		err = l.renderProfile(images, chart, valuesOpts, profile.name)
 		if err != nil {
 			return nil, err
 		}
 	}
+	if len(l.profiles) == 0 {
+		err = l.renderProfile(images, chart, l.valuesOpts, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	invalid := images.getInvalid()
+	if len(invalid) > 0 {
+		for image, sources := range invalid {
+			for _, source := range sources {
+				log.Printf("Error: invalid image reference %q found in %s\n", image, source)
+			}
+		}
+		return nil, fmt.Errorf("found %d invalid image references", len(invalid))
+	}
 
 	spent := duration(time.Since(start))
 	if l.debug {
 		log.Printf("Chart parsed in %s\n", spent)
 	}
 
-	return images.get(), nil
+	return images, nil
+}
+
+// resolveImageDigests queries the registries for the manifest each image tag points to
+func (l *listCmd) resolveImageDigests(images *imagesList) error {
+	addAuthRegistries(l.auths, l.debug)
+	resolver := containerd.NewResolver(registry.ConsoleCredentials, false)
+	ctx := context.Background()
+	for _, image := range images.get() {
+		if l.verbose {
+			fmt.Fprintf(os.Stderr, "Resolving %s...\n", image)
+		}
+		resolved, err := containerd.ResolveImage(ctx, resolver, image)
+		if err != nil {
+			return err
+		}
+		images.setResolved(image, resolved)
+	}
+	return nil
 }
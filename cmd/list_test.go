@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImagesList(t *testing.T) {
+	deployment := imageSource{Chart: "app", Template: "app/templates/deployment.yaml", Kind: "Deployment", Name: "app", Container: "app"}
+	job := imageSource{Chart: "app", Template: "app/templates/job.yaml", Kind: "Job", Name: "migrate", Container: "migrate"}
+	heuristic := imageSource{Chart: "app", Template: "app/templates/app.yaml", Kind: "App", Name: "app", Container: "app", Heuristic: true}
+	tests := []struct {
+		name     string
+		refStyle string
+		added    []string
+		expected []string
+		invalid  []string
+	}{
+		{
+			name:     "as rendered",
+			refStyle: refStyleAsRendered,
+			added:    []string{"nginx", "docker.io/library/nginx:latest", "nginx:latest", "quay.io/prometheus/prometheus:v2.45.0"},
+			expected: []string{"docker.io/library/nginx:latest", "quay.io/prometheus/prometheus:v2.45.0"},
+		},
+		{
+			name:     "full",
+			refStyle: refStyleFull,
+			added:    []string{"nginx:latest", "library/nginx", "bitnami/redis:7.0", "docker.io/bitnami/redis:7.0"},
+			expected: []string{"docker.io/bitnami/redis:7.0", "docker.io/library/nginx:latest"},
+		},
+		{
+			name:     "familiar",
+			refStyle: refStyleFamiliar,
+			added:    []string{"docker.io/library/nginx", "index.docker.io/bitnami/redis:7.0", "quay.io/prometheus/prometheus:v2.45.0"},
+			expected: []string{"bitnami/redis:7.0", "nginx:latest", "quay.io/prometheus/prometheus:v2.45.0"},
+		},
+		{
+			name:     "digests",
+			refStyle: refStyleFull,
+			added:    []string{"nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", "nginx:1.25@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+			expected: []string{"docker.io/library/nginx:1.25@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", "docker.io/library/nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+		},
+		{
+			name:     "invalid references",
+			refStyle: refStyleAsRendered,
+			added:    []string{"nginx:1.25", "Nginx:1.25", "nginx:"},
+			expected: []string{"nginx:1.25"},
+			invalid:  []string{"Nginx:1.25", "nginx:"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			images := newImagesList(test.refStyle)
+			for _, image := range test.added {
+				images.add(image, deployment)
+			}
+			if found := images.get(); !reflect.DeepEqual(found, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, found)
+			}
+			for _, image := range test.added {
+				if !images.contains(image) {
+					t.Errorf("%s not found", image)
+				}
+			}
+			invalid := images.getInvalid()
+			if len(invalid) != len(test.invalid) {
+				t.Errorf("expected invalid %v, got %v", test.invalid, invalid)
+			}
+			for _, image := range test.invalid {
+				if _, ok := invalid[image]; !ok {
+					t.Errorf("expected invalid %s", image)
+				}
+			}
+		})
+	}
+
+	t.Run("sources merged", func(t *testing.T) {
+		images := newImagesList(refStyleAsRendered)
+		images.add("nginx:1.25", deployment)
+		images.add("docker.io/library/nginx:1.25", job)
+		images.add("nginx:1.25", deployment)
+		images.add("envoy:v1.26", heuristic)
+		images.add("nginx:1.25", heuristic)
+		expected := []imageSource{heuristic, deployment, job}
+		if sources := images.getSources("docker.io/library/nginx:1.25"); !reflect.DeepEqual(sources, expected) {
+			t.Errorf("expected %v, got %v", expected, sources)
+		}
+		if images.isHeuristic("nginx:1.25") {
+			t.Error("nginx:1.25 found in known resources is heuristic")
+		}
+		if !images.isHeuristic("envoy:v1.26") {
+			t.Error("envoy:v1.26 only found heuristically is not heuristic")
+		}
+	})
+}
+
+func TestExcludeImages(t *testing.T) {
+	images := []string{"nginx", "docker.io/bitnami/redis:7.0", "quay.io/prometheus/prometheus:v2.45.0"}
+	excludes := []string{"docker.io/library/nginx:latest", "bitnami/redis:7.0", "quay.io/prometheus/prometheus:v2.44.0"}
+	expected := []string{"quay.io/prometheus/prometheus:v2.45.0"}
+	if included := excludeImages(images, excludes); !reflect.DeepEqual(included, expected) {
+		t.Errorf("expected %v, got %v", expected, included)
+	}
+}
+
+func TestRenderChartError(t *testing.T) {
+	// more renderings than workers, all of them failing
+	files := map[string]string{
+		"chart/templates/fail.yaml": `{{ fail "rendering failed" }}`,
+	}
+	chartYaml := "apiVersion: v2\nname: chart\nversion: 0.1.0\ndependencies:\n"
+	values := ""
+	for i := 0; i < runtime.NumCPU()+2; i++ {
+		name := fmt.Sprintf("sub%d", i)
+		chartYaml += fmt.Sprintf("- name: %s\n  version: 0.1.0\n  condition: %s.enabled\n", name, name)
+		values += fmt.Sprintf("%s:\n  enabled: false\n  weight: %d\n", name, i)
+		files["chart/charts/"+name+"/Chart.yaml"] = fmt.Sprintf("apiVersion: v2\nname: %s\nversion: 0.1.0\n", name)
+	}
+	files["chart/Chart.yaml"] = chartYaml
+	files["chart/values.yaml"] = values
+	l := &listCmd{
+		chartName:    filepath.Join(writeTestChart(t, files), "chart"),
+		namespace:    "default",
+		allSubcharts: true,
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.list()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "rendering failed") {
+			t.Errorf("expected rendering error, got %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("rendering of sub-charts did not end")
+	}
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/gemalto/helm-image/internal/containerd"
+	"github.com/gemalto/helm-image/internal/registry"
+	"github.com/spf13/cobra"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+type loadCmd struct {
+	archiveFile  string
+	target       string
+	pathMappings []string
+	plainHTTP    bool
+	excludes     []string
+	auths        []string
+	verbose      bool
+	debug        bool
+}
+
+func newLoadCmd(out io.Writer) *cobra.Command {
+	l := &loadCmd{}
+
+	cmd := &cobra.Command{
+		Use:          "load",
+		Short:        "push docker images of an archive written by save to a registry",
+		Long:         "push docker images of an archive written by save to a registry",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			l.archiveFile = args[0]
+			if len(l.target) == 0 {
+				return fmt.Errorf("--to is required")
+			}
+			return l.load()
+		},
+	}
+
+	flags := cmd.Flags()
+
+	flags.StringVar(&l.target, "to", "", "registry (optionally followed by a path prefix) where to push images, e.g. registry.local/prefix")
+	flags.StringSliceVar(&l.pathMappings, "map", []string{}, "relocate the repositories starting with a prefix under another path of the registry, as from=to (can specify multiple)")
+	flags.BoolVar(&l.plainHTTP, "plain-http", false, "reach the target registry through plain HTTP instead of HTTPS")
+	flags.StringSliceVarP(&l.auths, "auth", "a", []string{}, "specify private registries which need authentication during push")
+	flags.StringSliceVarP(&l.excludes, "exclude", "x", []string{}, "specify docker images to be excluded from push")
+	flags.BoolVarP(&l.verbose, "verbose", "v", false, "enable verbose output")
+
+	// When called through helm, debug mode is transmitted through the HELM_DEBUG envvar
+	helmDebug := os.Getenv("HELM_DEBUG")
+	if helmDebug == "1" || strings.EqualFold(helmDebug, "true") || strings.EqualFold(helmDebug, "on") {
+		l.debug = true
+	}
+
+	return cmd
+}
+
+func (l *loadCmd) load() error {
+	pathMappings, err := registry.ParsePathMappings(l.pathMappings)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	archive, err := containerd.OpenArchive(ctx, l.archiveFile)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	images := excludeImages(archive.Images(), l.excludes)
+	if len(images) == 0 {
+		return fmt.Errorf("no images to push from %s", l.archiveFile)
+	}
+	addAuthRegistries(l.auths, l.debug)
+	mirror := containerd.NewMirror(registry.ConsoleCredentials, l.plainHTTP, l.verbose)
+	for _, image := range images {
+		imageRef, err := registry.ParseImageRef(image)
+		if err != nil {
+			return err
+		}
+		targetRef, err := registry.MirrorImageRef(imageRef, l.target, pathMappings)
+		if err != nil {
+			return err
+		}
+		if l.debug {
+			log.Printf("Pushing %s as %s\n", image, targetRef)
+		}
+		err = mirror.PushArchiveImage(ctx, archive, image, targetRef.String())
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Successfully pushed all images of %s\n", l.archiveFile)
+	return nil
+}
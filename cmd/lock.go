@@ -0,0 +1,316 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/distribution/reference"
+	"github.com/gemalto/helm-image/internal/containerd"
+	"github.com/gemalto/helm-image/internal/registry"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	cliValues "helm.sh/helm/v3/pkg/cli/values"
+	"io"
+	"log"
+	"os"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+const defaultLockFile = "images.lock"
+
+type lockedPlatform struct {
+	Platform string `json:"platform"`
+	Digest   string `json:"digest"`
+}
+
+type lockedImage struct {
+	Image     string           `json:"image"`
+	Tag       string           `json:"tag,omitempty"`
+	Digest    string           `json:"digest"`
+	MediaType string           `json:"mediaType,omitempty"`
+	Platforms []lockedPlatform `json:"platforms,omitempty"`
+	Charts    []string         `json:"charts,omitempty"`
+}
+
+// imagesLock pins the images referenced by a chart to the digests their tags pointed to when the lock was written
+type imagesLock struct {
+	Chart   string        `json:"chart"`
+	Version string        `json:"version,omitempty"`
+	Images  []lockedImage `json:"images"`
+}
+
+func readLock(fileName string) (*imagesLock, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("reading lock file: %w", err)
+	}
+	lock := &imagesLock{}
+	err = yaml.UnmarshalStrict(content, lock)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", fileName, err)
+	}
+	return lock, nil
+}
+
+func writeLock(fileName string, lock *imagesLock) error {
+	content, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, content, 0644)
+}
+
+// find returns the locked image matching the given one, whatever the style of its reference
+func (l *imagesLock) find(image string) *lockedImage {
+	name := normalizedImage(image)
+	for i := range l.Images {
+		if normalizedImage(l.Images[i].Image) == name {
+			return &l.Images[i]
+		}
+	}
+	return nil
+}
+
+// pinsPlatforms returns true when an image with another digest than the locked one only holds locked platform
+// manifests, like the indexes restricted to some platforms written by save, or a single platform manifest
+func (l *lockedImage) pinsPlatforms(resolved *containerd.ResolvedImage, platformImages []containerd.PlatformImage) bool {
+	if len(l.Platforms) == 0 {
+		return false
+	}
+	locked := map[string]string{}
+	for _, platform := range l.Platforms {
+		locked[platform.Digest] = platform.Platform
+	}
+	if !resolved.Index {
+		_, ok := locked[resolved.Digest.String()]
+		return ok
+	}
+	if len(platformImages) == 0 {
+		return false
+	}
+	for _, platformImage := range platformImages {
+		if platform, ok := locked[platformImage.Digest.String()]; !ok || platform != platformImage.Platform {
+			return false
+		}
+	}
+	return true
+}
+
+// pinImages returns the images with the digests they are pinned to in the given lock file
+func pinImages(images []string, lockFile string) ([]string, error) {
+	lock, err := readLock(lockFile)
+	if err != nil {
+		return nil, err
+	}
+	var pinnedImages []string
+	for _, image := range images {
+		locked := lock.find(image)
+		if locked == nil {
+			return nil, fmt.Errorf("image %s is not pinned in %s, please update it with helm image lock", image, lockFile)
+		}
+		if strings.Contains(image, "@") {
+			pinnedImages = append(pinnedImages, image)
+		} else {
+			pinnedImages = append(pinnedImages, image+"@"+locked.Digest)
+		}
+	}
+	return pinnedImages, nil
+}
+
+type lockCmd struct {
+	chartName     string
+	chartPathOpts action.ChartPathOptions
+	devel         bool
+	namespace     string
+	lockFile      string
+	verify        bool
+	auths         []string
+	valuesOpts    cliValues.Options
+	allSubcharts  bool
+	rulesFiles    []string
+	heuristic     bool
+	verbose       bool
+	debug         bool
+}
+
+func newLockCmd(out io.Writer) *cobra.Command {
+	c := &lockCmd{}
+
+	cmd := &cobra.Command{
+		Use:          "lock",
+		Short:        "pin docker images referenced in a chart to their digests",
+		Long:         "pin docker images referenced in a chart to their digests",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.chartName = args[0]
+			return c.lock(out)
+		},
+	}
+
+	flags := cmd.Flags()
+
+	flags.StringSliceVarP(&c.auths, "auth", "a", []string{}, "specify private registries which need authentication when resolving digests")
+	flags.StringVar(&c.lockFile, "file", defaultLockFile, "lock file name")
+	flags.BoolVar(&c.verify, "verify", false, "verify that the images of the chart still match the lock file instead of writing it")
+	flags.StringVar(&c.chartPathOpts.Version, "version", "", "specify a version constraint for the chart version to use, latest version being used if not set")
+	flags.StringVar(&c.chartPathOpts.RepoURL, "repo", "", "chart repository url where to locate the requested chart")
+	flags.StringVar(&c.chartPathOpts.Username, "username", "", "chart repository username where to locate the requested chart")
+	flags.StringVar(&c.chartPathOpts.Password, "password", "", "chart repository password where to locate the requested chart")
+	flags.BoolVar(&c.devel, "devel", false, "use development versions, too (equivalent to version '>0.0.0-0'), ignored if --version is set")
+	flags.StringSliceVarP(&c.valuesOpts.ValueFiles, "values", "f", []string{}, "specify values in a YAML file or a URL (can specify multiple)")
+	flags.StringArrayVar(&c.valuesOpts.Values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&c.valuesOpts.StringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&c.valuesOpts.FileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	flags.BoolVar(&c.allSubcharts, "all-subcharts", false, "search all sub-charts, forcing their conditions and tags to true by groups of same weight")
+	flags.StringSliceVar(&c.rulesFiles, "rules", []string{}, "specify image extraction rules for custom resources in a YAML file (can specify multiple)")
+	flags.BoolVar(&c.heuristic, "heuristic", true, "search for containers at any depth of resources which are neither known nor matched by a rule")
+	flags.BoolVarP(&c.verbose, "verbose", "v", false, "enable verbose output")
+
+	// When called through helm, debug mode is transmitted through the HELM_DEBUG envvar
+	helmDebug := os.Getenv("HELM_DEBUG")
+	if helmDebug == "1" || strings.EqualFold(helmDebug, "true") || strings.EqualFold(helmDebug, "on") {
+		c.debug = true
+	}
+
+	// When called through helm, namespace is transmitted through the HELM_NAMESPACE envvar
+	namespace := os.Getenv("HELM_NAMESPACE")
+	if len(namespace) > 0 {
+		c.namespace = namespace
+	} else {
+		c.namespace = "default"
+	}
+
+	return cmd
+}
+
+func imageCharts(sources []imageSource) []string {
+	charts := map[string]struct{}{}
+	for _, source := range sources {
+		charts[source.Chart] = struct{}{}
+	}
+	var names []string
+	for name := range charts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lockImages resolves the digests of all the images and of their platforms
+func (c *lockCmd) lockImages(images *imagesList) ([]lockedImage, error) {
+	addAuthRegistries(c.auths, c.debug)
+	resolver := containerd.NewResolver(registry.ConsoleCredentials, false)
+	ctx := context.Background()
+	var lockedImages []lockedImage
+	for _, image := range images.get() {
+		if c.verbose {
+			fmt.Fprintf(os.Stderr, "Resolving %s...\n", image)
+		}
+		resolved, err := containerd.ResolveImage(ctx, resolver, image)
+		if err != nil {
+			return nil, err
+		}
+		platformImages, err := containerd.ResolvePlatforms(ctx, resolver, resolved)
+		if err != nil {
+			return nil, err
+		}
+		locked := lockedImage{
+			Image:     image,
+			Digest:    resolved.Digest.String(),
+			MediaType: resolved.MediaType,
+			Charts:    imageCharts(images.getSources(image)),
+		}
+		if ref, err := registry.ParseImageRef(image); err == nil {
+			if tagged, ok := ref.(reference.Tagged); ok {
+				locked.Tag = tagged.Tag()
+			}
+		}
+		for _, platformImage := range platformImages {
+			locked.Platforms = append(locked.Platforms, lockedPlatform{
+				Platform: platformImage.Platform,
+				Digest:   platformImage.Digest.String(),
+			})
+		}
+		lockedImages = append(lockedImages, locked)
+	}
+	return lockedImages, nil
+}
+
+// verifyLock reports the images which are not in the lock file anymore or yet, or whose tag now points to another digest
+func (c *lockCmd) verifyLock(lock *imagesLock, lockedImages []lockedImage) error {
+	drifts := 0
+	current := &imagesLock{Images: lockedImages}
+	for _, image := range lockedImages {
+		locked := lock.find(image.Image)
+		if locked == nil {
+			log.Printf("Error: image %s is not pinned in %s\n", image.Image, c.lockFile)
+			drifts++
+		} else if locked.Digest != image.Digest {
+			log.Printf("Error: image %s now points to %s instead of %s\n", image.Image, image.Digest, locked.Digest)
+			drifts++
+		}
+	}
+	for _, locked := range lock.Images {
+		if current.find(locked.Image) == nil {
+			log.Printf("Error: image %s pinned in %s is no longer referenced by the chart\n", locked.Image, c.lockFile)
+			drifts++
+		}
+	}
+	if drifts > 0 {
+		return fmt.Errorf("found %d differences with %s", drifts, c.lockFile)
+	}
+	return nil
+}
+
+func (c *lockCmd) lock(out io.Writer) error {
+	l := &listCmd{
+		chartName:     c.chartName,
+		chartPathOpts: c.chartPathOpts,
+		devel:         c.devel,
+		namespace:     c.namespace,
+		valuesOpts:    c.valuesOpts,
+		allSubcharts:  c.allSubcharts,
+		rulesFiles:    c.rulesFiles,
+		heuristic:     c.heuristic,
+		refStyle:      refStyleFull,
+		debug:         c.debug,
+		verbose:       c.verbose,
+	}
+	images, err := l.list()
+	if err != nil {
+		return err
+	}
+	chart, err := loader.Load(l.chartPath)
+	if err != nil {
+		return err
+	}
+	lockedImages, err := c.lockImages(images)
+	if err != nil {
+		return err
+	}
+	if c.verify {
+		lock, err := readLock(c.lockFile)
+		if err != nil {
+			return err
+		}
+		err = c.verifyLock(lock, lockedImages)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Images of %s match %s\n", chart.Name(), c.lockFile)
+		return nil
+	}
+	err = writeLock(c.lockFile, &imagesLock{
+		Chart:   chart.Name(),
+		Version: chart.Metadata.Version,
+		Images:  lockedImages,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Successfully pinned %d images in %s\n", len(lockedImages), c.lockFile)
+	return nil
+}
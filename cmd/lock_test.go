@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"github.com/gemalto/helm-image/internal/containerd"
+	"testing"
+)
+
+func TestPinsPlatforms(t *testing.T) {
+	locked := &lockedImage{
+		Image:  "nginx:1.25",
+		Digest: "sha256:index",
+		Platforms: []lockedPlatform{
+			{Platform: "linux/amd64", Digest: "sha256:amd64"},
+			{Platform: "linux/arm64", Digest: "sha256:arm64"},
+		},
+	}
+	tests := []struct {
+		name           string
+		locked         *lockedImage
+		resolved       *containerd.ResolvedImage
+		platformImages []containerd.PlatformImage
+		expected       bool
+	}{
+		{
+			name:     "index restricted to a pinned platform",
+			locked:   locked,
+			resolved: &containerd.ResolvedImage{Digest: "sha256:other", Index: true},
+			platformImages: []containerd.PlatformImage{
+				{Platform: "linux/amd64", Digest: "sha256:amd64"},
+			},
+			expected: true,
+		},
+		{
+			name:     "index with a platform manifest not pinned",
+			locked:   locked,
+			resolved: &containerd.ResolvedImage{Digest: "sha256:other", Index: true},
+			platformImages: []containerd.PlatformImage{
+				{Platform: "linux/amd64", Digest: "sha256:amd64"},
+				{Platform: "linux/arm64", Digest: "sha256:rebuilt"},
+			},
+			expected: false,
+		},
+		{
+			name:     "pinned digest of another platform",
+			locked:   locked,
+			resolved: &containerd.ResolvedImage{Digest: "sha256:other", Index: true},
+			platformImages: []containerd.PlatformImage{
+				{Platform: "linux/arm64", Digest: "sha256:amd64"},
+			},
+			expected: false,
+		},
+		{
+			name:     "single pinned platform manifest",
+			locked:   locked,
+			resolved: &containerd.ResolvedImage{Digest: "sha256:arm64"},
+			expected: true,
+		},
+		{
+			name:     "single manifest not pinned",
+			locked:   locked,
+			resolved: &containerd.ResolvedImage{Digest: "sha256:other"},
+			expected: false,
+		},
+		{
+			name:     "lock without platforms",
+			locked:   &lockedImage{Image: "nginx:1.25", Digest: "sha256:manifest"},
+			resolved: &containerd.ResolvedImage{Digest: "sha256:other"},
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.locked.pinsPlatforms(test.resolved, test.platformImages); actual != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/gemalto/helm-image/internal/rules"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+func (l *listCmd) addImage(images *imagesList, image string, source imageSource) {
+	if !images.contains(image) {
+		if l.verbose {
+			if source.Heuristic {
+				fmt.Fprintf(os.Stderr, "Found %s (heuristic)\n", image)
+			} else {
+				fmt.Fprintf(os.Stderr, "Found %s\n", image)
+			}
+		}
+	} else if l.debug {
+		fmt.Fprintf(os.Stderr, "Ignoring %s\n", image)
+	}
+	images.add(image, source)
+}
+
+func containerSource(source imageSource, container string, init bool, ephemeral bool) imageSource {
+	source.Container = container
+	source.Init = init
+	source.Ephemeral = ephemeral
+	return source
+}
+
+func (l *listCmd) addPodSpecImages(images *imagesList, podSpec *corev1.PodSpec, source imageSource) {
+	for _, container := range podSpec.Containers {
+		l.addImage(images, container.Image, containerSource(source, container.Name, false, false))
+	}
+	for _, container := range podSpec.InitContainers {
+		l.addImage(images, container.Image, containerSource(source, container.Name, true, false))
+	}
+	for _, container := range podSpec.EphemeralContainers {
+		l.addImage(images, container.Image, containerSource(source, container.Name, false, true))
+	}
+}
+
+// podSpecs returns the kind and the pod specifications carried by any built-in workload, whatever its API version
+func podSpecs(manifest k8sruntime.Object) (string, []*corev1.PodSpec) {
+	switch m := manifest.(type) {
+	case *corev1.Pod:
+		return "pod", []*corev1.PodSpec{&m.Spec}
+	case *corev1.PodTemplate:
+		return "pod template", []*corev1.PodSpec{&m.Template.Spec}
+	case *corev1.ReplicationController:
+		if m.Spec.Template != nil {
+			return "replication controller", []*corev1.PodSpec{&m.Spec.Template.Spec}
+		}
+		return "replication controller", nil
+	case *appsv1.Deployment:
+		return "deployment", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *appsv1.StatefulSet:
+		return "statefulset", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *appsv1.DaemonSet:
+		return "daemonset", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *appsv1.ReplicaSet:
+		return "replicaset", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *appsv1beta1.Deployment:
+		return "deployment", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *appsv1beta1.StatefulSet:
+		return "statefulset", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *appsv1beta2.Deployment:
+		return "deployment", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *appsv1beta2.StatefulSet:
+		return "statefulset", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *appsv1beta2.DaemonSet:
+		return "daemonset", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *appsv1beta2.ReplicaSet:
+		return "replicaset", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *extensionsv1beta1.Deployment:
+		return "deployment", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *extensionsv1beta1.DaemonSet:
+		return "daemonset", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *extensionsv1beta1.ReplicaSet:
+		return "replicaset", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *batchv1.Job:
+		return "job", []*corev1.PodSpec{&m.Spec.Template.Spec}
+	case *batchv1.CronJob:
+		return "cron job", []*corev1.PodSpec{&m.Spec.JobTemplate.Spec.Template.Spec}
+	case *batchv1beta1.CronJob:
+		return "cron job", []*corev1.PodSpec{&m.Spec.JobTemplate.Spec.Template.Spec}
+	}
+	return "", nil
+}
+
+// isEmptyDocument returns true when a YAML document only contains blank lines or comments
+func isEmptyDocument(document []byte) bool {
+	for _, line := range strings.Split(string(document), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
+}
+
+type foundContainer struct {
+	name      string
+	image     string
+	init      bool
+	ephemeral bool
+}
+
+// findContainers walks an unstructured tree and returns all containers, init containers
+// and ephemeral containers found at any depth
+func findContainers(node interface{}) []foundContainer {
+	var containers []foundContainer
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, value := range n {
+			if key == "containers" || key == "initContainers" || key == "ephemeralContainers" {
+				if items, ok := value.([]interface{}); ok {
+					for _, item := range items {
+						if c, ok := item.(map[string]interface{}); ok {
+							if image, ok := c["image"].(string); ok && len(image) > 0 {
+								name, _ := c["name"].(string)
+								containers = append(containers, foundContainer{
+									name:      name,
+									image:     image,
+									init:      key == "initContainers",
+									ephemeral: key == "ephemeralContainers",
+								})
+							}
+						}
+					}
+				}
+			}
+			containers = append(containers, findContainers(value)...)
+		}
+	case []interface{}:
+		for _, value := range n {
+			containers = append(containers, findContainers(value)...)
+		}
+	}
+	return containers
+}
+
+// addRulesImages applies the extraction rules matching a resource to its unstructured content
+func (l *listCmd) addRulesImages(images *imagesList, object map[string]interface{}, matchingRules []rules.Rule, location string, source imageSource) error {
+	for _, rule := range matchingRules {
+		for _, ruleImage := range rule.Images {
+			found, err := ruleImage.Images(object)
+			if err != nil {
+				return fmt.Errorf("applying rules for %s %s to %s: %w", rule.APIVersion, rule.Kind, location, err)
+			}
+			for _, image := range found {
+				l.addImage(images, image, source)
+			}
+		}
+	}
+	return nil
+}
+
+// addRegisteredRulesImages applies the extraction rules matching a resource known by the scheme, in addition to
+// the images found in its typed specification, e.g. for images passed as arguments or environment variables
+func (l *listCmd) addRegisteredRulesImages(images *imagesList, manifest k8sruntime.Object, location string, source imageSource) error {
+	apiVersion, kind := manifest.GetObjectKind().GroupVersionKind().ToAPIVersionAndKind()
+	matchingRules := l.rules.Match(apiVersion, kind)
+	if len(matchingRules) == 0 {
+		return nil
+	}
+	if l.debug {
+		log.Printf("Applying rules for %s %s to %s...\n", apiVersion, kind, location)
+	}
+	object, err := k8sruntime.DefaultUnstructuredConverter.ToUnstructured(manifest)
+	if err != nil {
+		return fmt.Errorf("converting %s: %w", location, err)
+	}
+	return l.addRulesImages(images, object, matchingRules, location, source)
+}
+
+// addUnregisteredImages applies the extraction rules to a document whose kind is not known by the scheme,
+// or searches heuristically for containers when no rule matches
+func (l *listCmd) addUnregisteredImages(images *imagesList, document []byte, location string, source imageSource) error {
+	object := &unstructured.Unstructured{}
+	err := yaml.Unmarshal(document, &object.Object)
+	if err != nil {
+		log.Printf("Warning: cannot parse %s: %s\n", location, err)
+		return nil
+	}
+	source.Kind = object.GetKind()
+	source.Namespace = object.GetNamespace()
+	source.Name = object.GetName()
+	matchingRules := l.rules.Match(object.GetAPIVersion(), object.GetKind())
+	if len(matchingRules) == 0 {
+		if !l.heuristic {
+			if l.debug {
+				log.Printf("Ignoring %s: no rule found for %s %s\n", location, object.GetAPIVersion(), object.GetKind())
+			}
+			return nil
+		}
+		if l.debug {
+			log.Printf("Searching heuristically for %s images in %s...\n", object.GetKind(), location)
+		}
+		source.Heuristic = true
+		for _, container := range findContainers(object.Object) {
+			l.addImage(images, container.image, containerSource(source, container.name, container.init, container.ephemeral))
+		}
+		return nil
+	}
+	if l.debug {
+		log.Printf("Searching for %s images in %s...\n", object.GetKind(), location)
+	}
+	return l.addRulesImages(images, object.Object, matchingRules, location, source)
+}
+
+// addDocumentImages decodes a single document and adds the images it references
+func (l *listCmd) addDocumentImages(images *imagesList, document []byte, location string, source imageSource) error {
+	manifest, _, err := scheme.Codecs.UniversalDeserializer().Decode(document, nil, nil)
+	if err != nil {
+		if k8sruntime.IsNotRegisteredError(err) {
+			return l.addUnregisteredImages(images, document, location, source)
+		}
+		log.Printf("Warning: cannot parse %s: %s\n", location, err)
+		return nil
+	}
+	return l.addObjectImages(images, manifest, location, source)
+}
+
+func (l *listCmd) addObjectImages(images *imagesList, manifest k8sruntime.Object, location string, source imageSource) error {
+	if meta.IsListType(manifest) {
+		items, err := meta.ExtractList(manifest)
+		if err != nil {
+			return fmt.Errorf("extracting items of %s: %w", location, err)
+		}
+		for i, item := range items {
+			itemLocation := fmt.Sprintf("item %d of %s", i+1, location)
+			if unknown, ok := item.(*k8sruntime.Unknown); ok {
+				err = l.addDocumentImages(images, unknown.Raw, itemLocation, source)
+			} else if item != nil {
+				err = l.addObjectImages(images, item, itemLocation, source)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	source.Kind = manifest.GetObjectKind().GroupVersionKind().Kind
+	if object, err := meta.Accessor(manifest); err == nil {
+		source.Namespace = object.GetNamespace()
+		source.Name = object.GetName()
+	}
+	if !l.addOpenShiftImages(images, manifest, location, source) {
+		kind, specs := podSpecs(manifest)
+		if len(specs) > 0 {
+			if l.debug {
+				log.Printf("Searching for %s images in %s...\n", kind, location)
+			}
+			for _, spec := range specs {
+				l.addPodSpecImages(images, spec, source)
+			}
+		}
+	}
+	return l.addRegisteredRulesImages(images, manifest, location, source)
+}
+
+// chartOfTemplate returns the path of the chart or sub-chart owning a rendered template
+func chartOfTemplate(name string) string {
+	if i := strings.LastIndex(name, "/templates/"); i >= 0 {
+		return name[:i]
+	}
+	return path.Dir(name)
+}
+
+// addManifestImages decodes every document of a rendered manifest and adds the images they reference
+func (l *listCmd) addManifestImages(images *imagesList, name string, content []byte, profile string) error {
+	source := imageSource{
+		Profile:  profile,
+		Chart:    chartOfTemplate(name),
+		Template: name,
+	}
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(content)))
+	for index := 1; ; index++ {
+		document, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading document %d of %s: %w", index, name, err)
+		}
+		if isEmptyDocument(document) {
+			continue
+		}
+		err = l.addDocumentImages(images, document, fmt.Sprintf("document %d of %s", index, name), source)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
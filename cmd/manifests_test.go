@@ -0,0 +1,506 @@
+package cmd
+
+import (
+	"github.com/gemalto/helm-image/internal/rules"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"testing"
+)
+
+func podSpecsImages(specs []*corev1.PodSpec) []string {
+	var images []string
+	for _, spec := range specs {
+		for _, container := range spec.Containers {
+			images = append(images, container.Image)
+		}
+		for _, container := range spec.InitContainers {
+			images = append(images, container.Image)
+		}
+		for _, container := range spec.EphemeralContainers {
+			images = append(images, container.Image)
+		}
+	}
+	return images
+}
+
+func TestPodSpecs(t *testing.T) {
+	template := `
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: busybox:1.36
+      containers:
+      - name: app
+        image: nginx:1.25
+`
+	tests := []struct {
+		name     string
+		manifest string
+		kind     string
+		images   []string
+	}{
+		{
+			name: "pod",
+			manifest: `apiVersion: v1
+kind: Pod
+spec:
+  containers:
+  - name: app
+    image: nginx:1.25
+  ephemeralContainers:
+  - name: debug
+    image: busybox:1.36
+`,
+			kind:   "pod",
+			images: []string{"nginx:1.25", "busybox:1.36"},
+		},
+		{
+			name: "pod template",
+			manifest: `apiVersion: v1
+kind: PodTemplate
+template:
+  spec:
+    containers:
+    - name: app
+      image: nginx:1.25
+`,
+			kind:   "pod template",
+			images: []string{"nginx:1.25"},
+		},
+		{
+			name:     "replication controller",
+			manifest: "apiVersion: v1\nkind: ReplicationController\nspec:" + template,
+			kind:     "replication controller",
+			images:   []string{"nginx:1.25", "busybox:1.36"},
+		},
+		{
+			name:     "replication controller without template",
+			manifest: "apiVersion: v1\nkind: ReplicationController\nspec:\n  replicas: 1\n",
+			kind:     "replication controller",
+		},
+		{
+			name:     "deployment",
+			manifest: "apiVersion: apps/v1\nkind: Deployment\nspec:" + template,
+			kind:     "deployment",
+			images:   []string{"nginx:1.25", "busybox:1.36"},
+		},
+		{
+			name:     "beta deployment",
+			manifest: "apiVersion: apps/v1beta1\nkind: Deployment\nspec:" + template,
+			kind:     "deployment",
+			images:   []string{"nginx:1.25", "busybox:1.36"},
+		},
+		{
+			name:     "extensions deployment",
+			manifest: "apiVersion: extensions/v1beta1\nkind: Deployment\nspec:" + template,
+			kind:     "deployment",
+			images:   []string{"nginx:1.25", "busybox:1.36"},
+		},
+		{
+			name:     "statefulset",
+			manifest: "apiVersion: apps/v1beta2\nkind: StatefulSet\nspec:" + template,
+			kind:     "statefulset",
+			images:   []string{"nginx:1.25", "busybox:1.36"},
+		},
+		{
+			name:     "daemonset",
+			manifest: "apiVersion: apps/v1\nkind: DaemonSet\nspec:" + template,
+			kind:     "daemonset",
+			images:   []string{"nginx:1.25", "busybox:1.36"},
+		},
+		{
+			name:     "replicaset",
+			manifest: "apiVersion: extensions/v1beta1\nkind: ReplicaSet\nspec:" + template,
+			kind:     "replicaset",
+			images:   []string{"nginx:1.25", "busybox:1.36"},
+		},
+		{
+			name:     "job",
+			manifest: "apiVersion: batch/v1\nkind: Job\nspec:" + template,
+			kind:     "job",
+			images:   []string{"nginx:1.25", "busybox:1.36"},
+		},
+		{
+			name: "cron job",
+			manifest: `apiVersion: batch/v1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: app
+            image: nginx:1.25
+`,
+			kind:   "cron job",
+			images: []string{"nginx:1.25"},
+		},
+		{
+			name: "beta cron job",
+			manifest: `apiVersion: batch/v1beta1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: app
+            image: nginx:1.25
+`,
+			kind:   "cron job",
+			images: []string{"nginx:1.25"},
+		},
+		{
+			name:     "no workload",
+			manifest: "apiVersion: v1\nkind: ConfigMap\ndata:\n  image: nginx:1.25\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manifest, _, err := scheme.Codecs.UniversalDeserializer().Decode([]byte(test.manifest), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kind, specs := podSpecs(manifest)
+			if kind != test.kind {
+				t.Errorf("expected kind %q, got %q", test.kind, kind)
+			}
+			if images := podSpecsImages(specs); !reflect.DeepEqual(images, test.images) {
+				t.Errorf("expected %v, got %v", test.images, images)
+			}
+		})
+	}
+}
+
+func testListCmd(t *testing.T) *listCmd {
+	r, err := rules.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &listCmd{
+		rules: r,
+	}
+}
+
+func manifestImages(t *testing.T, l *listCmd, content string) []string {
+	images := newImagesList(refStyleAsRendered)
+	err := l.addManifestImages(images, "chart/templates/test.yaml", []byte(content), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := images.get()
+	sort.Strings(found)
+	return found
+}
+
+func TestAddManifestImages(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name: "several documents",
+			content: `---
+# Source: chart/templates/test.yaml
+apiVersion: v1
+kind: Pod
+spec:
+  containers:
+  - name: app
+    image: nginx:1.25
+---
+# empty document
+---
+
+---
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: busybox:1.36
+---
+apiVersion: batch/v1
+kind: Job
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: alpine:3.18`,
+			expected: []string{"alpine:3.18", "busybox:1.36", "nginx:1.25"},
+		},
+		{
+			name: "list",
+			content: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  spec:
+    containers:
+    - name: app
+      image: nginx:1.25
+- apiVersion: v1
+  kind: ConfigMap
+  data:
+    image: alpine:3.18
+- apiVersion: apps/v1
+  kind: DaemonSet
+  spec:
+    template:
+      spec:
+        containers:
+        - name: app
+          image: busybox:1.36
+`,
+			expected: []string{"busybox:1.36", "nginx:1.25"},
+		},
+		{
+			name: "typed list",
+			content: `apiVersion: apps/v1
+kind: DeploymentList
+items:
+- spec:
+    template:
+      spec:
+        containers:
+        - name: app
+          image: nginx:1.25
+`,
+			expected: []string{"nginx:1.25"},
+		},
+		{
+			name: "custom resource matched by a rule",
+			content: `apiVersion: monitoring.coreos.com/v1
+kind: Prometheus
+spec:
+  baseImage: quay.io/prometheus/prometheus
+  version: v2.45.0
+  containers:
+  - name: sidecar
+    image: busybox:1.36
+`,
+			expected: []string{"busybox:1.36", "quay.io/prometheus/prometheus:v2.45.0"},
+		},
+		{
+			name: "unknown kind and invalid document skipped",
+			content: `apiVersion: example.com/v1
+kind: App
+spec:
+  image: alpine:3.18
+---
+apiVersion: v1
+kind: Pod
+spec: [
+---
+apiVersion: v1
+kind: Pod
+spec:
+  containers:
+  - name: app
+    image: nginx:1.25
+`,
+			expected: []string{"nginx:1.25"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if images := manifestImages(t, testListCmd(t), test.content); !reflect.DeepEqual(images, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, images)
+			}
+		})
+	}
+}
+
+func TestUserRules(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "rules.yaml")
+	err := os.WriteFile(fileName, []byte(`rules:
+- apiVersion: example.com/*
+  kind: App
+  images:
+  - path: .spec.image
+  - repository: .spec.sidecars[*].repository
+    tag: .spec.sidecars[*].tag
+- apiVersion: apps/v1
+  kind: Deployment
+  images:
+  - path: .spec.template.spec.containers[*].env[?(@.name=="SIDECAR_IMAGE")].value
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rules.Load([]string{fileName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &listCmd{
+		rules: r,
+	}
+	images := manifestImages(t, l, `apiVersion: example.com/v1alpha1
+kind: App
+spec:
+  image: nginx:1.25
+  sidecars:
+  - repository: envoy
+    tag: v1.26
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.25
+        env:
+        - name: SIDECAR_IMAGE
+          value: fluent/fluent-bit:2.1
+`)
+	expected := []string{"envoy:v1.26", "fluent/fluent-bit:2.1", "nginx:1.25"}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("expected %v, got %v", expected, images)
+	}
+}
+
+func TestFindContainers(t *testing.T) {
+	var object map[string]interface{}
+	err := yaml.Unmarshal([]byte(`apiVersion: example.com/v1
+kind: Pipeline
+spec:
+  containers: not a list
+  template:
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.25
+      - name: without image
+      initContainers:
+      - name: init
+        image: busybox:1.36
+  tasks:
+  - steps:
+      ephemeralContainers:
+      - name: debug
+        image: alpine:3.18
+  - image: ignored:1.0
+`), &object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containers := findContainers(object)
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].image < containers[j].image
+	})
+	expected := []foundContainer{
+		{name: "debug", image: "alpine:3.18", ephemeral: true},
+		{name: "init", image: "busybox:1.36", init: true},
+		{name: "app", image: "nginx:1.25"},
+	}
+	if !reflect.DeepEqual(containers, expected) {
+		t.Errorf("expected %v, got %v", expected, containers)
+	}
+}
+
+func TestHeuristicImages(t *testing.T) {
+	unknown := `apiVersion: example.com/v1
+kind: App
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.25
+      - name: sidecar
+        image: envoy:v1.26
+`
+	deployment := `apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.25
+`
+	matched := `apiVersion: monitoring.coreos.com/v1
+kind: Alertmanager
+spec:
+  image: quay.io/prometheus/alertmanager:v0.25.0
+  template:
+    spec:
+      containers:
+      - name: ignored
+        image: ignored:1.0
+`
+	tests := []struct {
+		name       string
+		heuristic  bool
+		content    string
+		expected   []string
+		heuristics []string
+	}{
+		{
+			name:       "unknown kind",
+			heuristic:  true,
+			content:    unknown,
+			expected:   []string{"envoy:v1.26", "nginx:1.25"},
+			heuristics: []string{"envoy:v1.26", "nginx:1.25"},
+		},
+		{
+			name:       "image also found in a known kind",
+			heuristic:  true,
+			content:    unknown + "---\n" + deployment,
+			expected:   []string{"envoy:v1.26", "nginx:1.25"},
+			heuristics: []string{"envoy:v1.26"},
+		},
+		{
+			name:    "heuristic disabled",
+			content: unknown,
+		},
+		{
+			name:      "kind matched by a rule",
+			heuristic: true,
+			content:   matched,
+			expected:  []string{"quay.io/prometheus/alertmanager:v0.25.0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := testListCmd(t)
+			l.heuristic = test.heuristic
+			images := newImagesList(refStyleAsRendered)
+			err := l.addManifestImages(images, "chart/templates/test.yaml", []byte(test.content), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := images.get()
+			sort.Strings(found)
+			if !reflect.DeepEqual(found, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, found)
+			}
+			var heuristics []string
+			for _, image := range found {
+				if images.isHeuristic(image) {
+					heuristics = append(heuristics, image)
+				}
+			}
+			if !reflect.DeepEqual(heuristics, test.heuristics) {
+				t.Errorf("expected heuristic images %v, got %v", test.heuristics, heuristics)
+			}
+		})
+	}
+}
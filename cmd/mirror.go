@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/gemalto/helm-image/internal/containerd"
+	"github.com/gemalto/helm-image/internal/registry"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/action"
+	cliValues "helm.sh/helm/v3/pkg/cli/values"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+type mirrorCmd struct {
+	chartName     string
+	chartPathOpts action.ChartPathOptions
+	devel         bool
+	namespace     string
+	target        string
+	pathMappings  []string
+	plainHTTP     bool
+	excludes      []string
+	auths         []string
+	valuesOpts    cliValues.Options
+	allSubcharts  bool
+	rulesFiles    []string
+	heuristic     bool
+	lockFile      string
+	verbose       bool
+	debug         bool
+}
+
+func newMirrorCmd(out io.Writer) *cobra.Command {
+	m := &mirrorCmd{}
+
+	cmd := &cobra.Command{
+		Use:          "mirror",
+		Short:        "copy docker images referenced in a chart to another registry",
+		Long:         "copy docker images referenced in a chart to another registry",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m.chartName = args[0]
+			if len(m.target) == 0 {
+				return fmt.Errorf("--to is required")
+			}
+			return m.mirror()
+		},
+	}
+
+	flags := cmd.Flags()
+
+	flags.StringVar(&m.target, "to", "", "registry (optionally followed by a path prefix) where to copy images, e.g. registry.internal/prefix")
+	flags.StringSliceVar(&m.pathMappings, "map", []string{}, "relocate the repositories starting with a prefix under another path of the registry, as from=to (can specify multiple)")
+	flags.BoolVar(&m.plainHTTP, "plain-http", false, "reach the target registry through plain HTTP instead of HTTPS")
+	flags.StringSliceVarP(&m.auths, "auth", "a", []string{}, "specify private registries which need authentication during copy")
+	flags.StringSliceVarP(&m.excludes, "exclude", "x", []string{}, "specify docker images to be excluded from copy")
+	flags.StringVar(&m.chartPathOpts.Version, "version", "", "specify a version constraint for the chart version to use, latest version being used if not set")
+	flags.StringVar(&m.chartPathOpts.RepoURL, "repo", "", "chart repository url where to locate the requested chart")
+	flags.StringVar(&m.chartPathOpts.Username, "username", "", "chart repository username where to locate the requested chart")
+	flags.StringVar(&m.chartPathOpts.Password, "password", "", "chart repository password where to locate the requested chart")
+	flags.BoolVar(&m.devel, "devel", false, "use development versions, too (equivalent to version '>0.0.0-0'), ignored if --version is set")
+	flags.StringSliceVarP(&m.valuesOpts.ValueFiles, "values", "f", []string{}, "specify values in a YAML file or a URL (can specify multiple)")
+	flags.StringArrayVar(&m.valuesOpts.Values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&m.valuesOpts.StringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&m.valuesOpts.FileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	flags.BoolVar(&m.allSubcharts, "all-subcharts", false, "search all sub-charts, forcing their conditions and tags to true by groups of same weight")
+	flags.StringSliceVar(&m.rulesFiles, "rules", []string{}, "specify image extraction rules for custom resources in a YAML file (can specify multiple)")
+	flags.BoolVar(&m.heuristic, "heuristic", true, "search for containers at any depth of resources which are neither known nor matched by a rule")
+	flags.StringVar(&m.lockFile, "lock", "", "copy the digests the images are pinned to in the given lock file (see lock command)")
+	flags.BoolVarP(&m.verbose, "verbose", "v", false, "enable verbose output")
+
+	// When called through helm, debug mode is transmitted through the HELM_DEBUG envvar
+	helmDebug := os.Getenv("HELM_DEBUG")
+	if helmDebug == "1" || strings.EqualFold(helmDebug, "true") || strings.EqualFold(helmDebug, "on") {
+		m.debug = true
+	}
+
+	// When called through helm, namespace is transmitted through the HELM_NAMESPACE envvar
+	namespace := os.Getenv("HELM_NAMESPACE")
+	if len(namespace) > 0 {
+		m.namespace = namespace
+	} else {
+		m.namespace = "default"
+	}
+
+	return cmd
+}
+
+func (m *mirrorCmd) mirror() error {
+	pathMappings, err := registry.ParsePathMappings(m.pathMappings)
+	if err != nil {
+		return err
+	}
+	l := &listCmd{
+		chartName:     m.chartName,
+		chartPathOpts: m.chartPathOpts,
+		devel:         m.devel,
+		namespace:     m.namespace,
+		valuesOpts:    m.valuesOpts,
+		allSubcharts:  m.allSubcharts,
+		rulesFiles:    m.rulesFiles,
+		heuristic:     m.heuristic,
+		debug:         m.debug,
+		verbose:       m.verbose,
+	}
+	images, err := l.list()
+	if err != nil {
+		return err
+	}
+	includedImages := excludeImages(images.get(), m.excludes)
+	sourceImages := includedImages
+	if len(m.lockFile) > 0 {
+		sourceImages, err = pinImages(includedImages, m.lockFile)
+		if err != nil {
+			return err
+		}
+	}
+	addAuthRegistries(m.auths, m.debug)
+	mirror := containerd.NewMirror(registry.ConsoleCredentials, m.plainHTTP, m.verbose)
+	ctx := context.Background()
+	for i, image := range sourceImages {
+		imageRef, err := registry.ParseImageRef(image)
+		if err != nil {
+			return err
+		}
+		targetRef, err := registry.MirrorImageRef(imageRef, m.target, pathMappings)
+		if err != nil {
+			return err
+		}
+		if m.debug {
+			log.Printf("Mirroring %s as %s\n", includedImages[i], targetRef)
+		}
+		err = mirror.MirrorImage(ctx, image, targetRef.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"fmt"
+	ocappsv1 "github.com/openshift/api/apps/v1"
+	ocbuildv1 "github.com/openshift/api/build/v1"
+	ocimagev1 "github.com/openshift/api/image/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"log"
+	"strings"
+	"sync"
+)
+
+func init() {
+	utilruntime.Must(ocappsv1.Install(scheme.Scheme))
+	utilruntime.Must(ocbuildv1.Install(scheme.Scheme))
+	utilruntime.Must(ocimagev1.Install(scheme.Scheme))
+	// resources of older charts are still written with the legacy v1 API version, without group
+	utilruntime.Must(ocappsv1.DeprecatedInstallWithoutGroup(scheme.Scheme))
+	utilruntime.Must(ocbuildv1.DeprecatedInstallWithoutGroup(scheme.Scheme))
+	utilruntime.Must(ocimagev1.DeprecatedInstallWithoutGroup(scheme.Scheme))
+}
+
+type imageStreamRef struct {
+	namespace string
+	name      string
+	fallback  string
+	location  string
+	source    imageSource
+}
+
+// imageStreams keeps track of the image stream tags defined in the chart, and of the references to resolve against them
+type imageStreams struct {
+	tags map[string]corev1.ObjectReference
+	refs []imageStreamRef
+	mu   sync.Mutex
+}
+
+func newImageStreams() *imageStreams {
+	return &imageStreams{
+		tags: map[string]corev1.ObjectReference{},
+	}
+}
+
+func imageStreamTagKey(namespace string, name string) string {
+	if !strings.Contains(name, ":") {
+		name = name + ":latest"
+	}
+	return namespace + "/" + name
+}
+
+func (s *imageStreams) addTag(namespace string, stream string, tag string, from corev1.ObjectReference) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a tag can point to another tag of the same image stream by only giving its name
+	if from.Kind == "ImageStreamTag" && !strings.Contains(from.Name, ":") {
+		from.Name = stream + ":" + from.Name
+	}
+	if len(from.Namespace) == 0 {
+		from.Namespace = namespace
+	}
+	s.tags[imageStreamTagKey(namespace, stream+":"+tag)] = from
+}
+
+func (s *imageStreams) addRef(ref imageStreamRef) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs = append(s.refs, ref)
+}
+
+// resolve follows image stream tags until a docker image is found
+func (s *imageStreams) resolve(namespace string, name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	visited := map[string]struct{}{}
+	for {
+		key := imageStreamTagKey(namespace, name)
+		if _, ok := visited[key]; ok {
+			return "", false
+		}
+		visited[key] = struct{}{}
+		from, ok := s.tags[key]
+		if !ok {
+			return "", false
+		}
+		switch from.Kind {
+		case "DockerImage":
+			return from.Name, true
+		case "ImageStreamTag":
+			namespace = from.Namespace
+			name = from.Name
+		default:
+			return "", false
+		}
+	}
+}
+
+func (l *listCmd) objectNamespace(namespace string) string {
+	if len(namespace) == 0 {
+		return l.namespace
+	}
+	return namespace
+}
+
+// addObjectReferenceImage adds the image of a docker image reference, or records an image stream tag reference
+// to be resolved once all manifests are parsed
+func (l *listCmd) addObjectReferenceImage(images *imagesList, ref *corev1.ObjectReference, namespace string, fallback string, location string, source imageSource) {
+	switch ref.Kind {
+	case "DockerImage":
+		l.addImage(images, ref.Name, source)
+	case "ImageStreamTag":
+		if len(ref.Namespace) > 0 {
+			namespace = ref.Namespace
+		}
+		images.streams.addRef(imageStreamRef{
+			namespace: namespace,
+			name:      ref.Name,
+			fallback:  fallback,
+			location:  location,
+			source:    source,
+		})
+	default:
+		if len(fallback) > 0 {
+			l.addImage(images, fallback, source)
+		} else {
+			log.Printf("Warning: cannot resolve %s %s referenced in %s\n", ref.Kind, ref.Name, location)
+		}
+	}
+}
+
+func (l *listCmd) addDeploymentConfigImages(images *imagesList, dc *ocappsv1.DeploymentConfig, location string, source imageSource) {
+	if dc.Spec.Template == nil {
+		return
+	}
+	namespace := l.objectNamespace(dc.Namespace)
+	triggers := map[string]*corev1.ObjectReference{}
+	for _, trigger := range dc.Spec.Triggers {
+		if trigger.Type == ocappsv1.DeploymentTriggerOnImageChange && trigger.ImageChangeParams != nil {
+			for _, containerName := range trigger.ImageChangeParams.ContainerNames {
+				triggers[containerName] = &trigger.ImageChangeParams.From
+			}
+		}
+	}
+	addContainerImage := func(container corev1.Container, init bool) {
+		containerSource := containerSource(source, container.Name, init, false)
+		// image of containers updated by an image change trigger is replaced at deployment time
+		if from, ok := triggers[container.Name]; ok {
+			l.addObjectReferenceImage(images, from, namespace, strings.TrimSpace(container.Image), fmt.Sprintf("container %s of %s", container.Name, location), containerSource)
+		} else {
+			l.addImage(images, container.Image, containerSource)
+		}
+	}
+	for _, container := range dc.Spec.Template.Spec.Containers {
+		addContainerImage(container, false)
+	}
+	for _, container := range dc.Spec.Template.Spec.InitContainers {
+		addContainerImage(container, true)
+	}
+	for _, container := range dc.Spec.Template.Spec.EphemeralContainers {
+		l.addImage(images, container.Image, containerSource(source, container.Name, false, true))
+	}
+}
+
+func (l *listCmd) addBuildConfigImages(images *imagesList, bc *ocbuildv1.BuildConfig, location string, source imageSource) {
+	namespace := l.objectNamespace(bc.Namespace)
+	strategy := bc.Spec.Strategy
+	var from *corev1.ObjectReference
+	switch {
+	case strategy.DockerStrategy != nil && strategy.DockerStrategy.From != nil:
+		from = strategy.DockerStrategy.From
+	case strategy.SourceStrategy != nil:
+		from = &strategy.SourceStrategy.From
+	case strategy.CustomStrategy != nil:
+		from = &strategy.CustomStrategy.From
+	}
+	if from != nil {
+		l.addObjectReferenceImage(images, from, namespace, "", fmt.Sprintf("strategy of %s", location), source)
+	}
+	for _, imageSource := range bc.Spec.Source.Images {
+		imageSource := imageSource
+		l.addObjectReferenceImage(images, &imageSource.From, namespace, "", fmt.Sprintf("source images of %s", location), source)
+	}
+	for _, trigger := range bc.Spec.Triggers {
+		if trigger.ImageChange != nil && trigger.ImageChange.From != nil {
+			l.addObjectReferenceImage(images, trigger.ImageChange.From, namespace, "", fmt.Sprintf("image change trigger of %s", location), source)
+		}
+	}
+}
+
+func (l *listCmd) addImageStreamImages(images *imagesList, is *ocimagev1.ImageStream, source imageSource) {
+	namespace := l.objectNamespace(is.Namespace)
+	for _, tag := range is.Spec.Tags {
+		if tag.From == nil {
+			continue
+		}
+		images.streams.addTag(namespace, is.Name, tag.Name, *tag.From)
+		if tag.From.Kind == "DockerImage" {
+			l.addImage(images, tag.From.Name, source)
+		}
+	}
+}
+
+func (l *listCmd) addImageStreamTagImages(images *imagesList, ist *ocimagev1.ImageStreamTag, source imageSource) {
+	if ist.Tag == nil || ist.Tag.From == nil {
+		return
+	}
+	namespace := l.objectNamespace(ist.Namespace)
+	nameParts := strings.SplitN(ist.Name, ":", 2)
+	if len(nameParts) != 2 {
+		return
+	}
+	images.streams.addTag(namespace, nameParts[0], nameParts[1], *ist.Tag.From)
+	if ist.Tag.From.Kind == "DockerImage" {
+		l.addImage(images, ist.Tag.From.Name, source)
+	}
+}
+
+// addOpenShiftImages adds the images of OpenShift resources, and returns false if the resource is not one of them
+func (l *listCmd) addOpenShiftImages(images *imagesList, manifest k8sruntime.Object, location string, source imageSource) bool {
+	switch m := manifest.(type) {
+	case *ocappsv1.DeploymentConfig:
+		if l.debug {
+			log.Printf("Searching for deployment config images in %s...\n", location)
+		}
+		l.addDeploymentConfigImages(images, m, location, source)
+	case *ocbuildv1.BuildConfig:
+		if l.debug {
+			log.Printf("Searching for build config images in %s...\n", location)
+		}
+		l.addBuildConfigImages(images, m, location, source)
+	case *ocimagev1.ImageStream:
+		if l.debug {
+			log.Printf("Searching for image stream images in %s...\n", location)
+		}
+		l.addImageStreamImages(images, m, source)
+	case *ocimagev1.ImageStreamTag:
+		if l.debug {
+			log.Printf("Searching for image stream tag images in %s...\n", location)
+		}
+		l.addImageStreamTagImages(images, m, source)
+	default:
+		return false
+	}
+	return true
+}
+
+// resolveImageStreams adds the images of the image stream tags referenced by OpenShift resources,
+// when the image streams are defined in the chart
+func (l *listCmd) resolveImageStreams(images *imagesList) {
+	for _, ref := range images.streams.refs {
+		image, ok := images.streams.resolve(ref.namespace, ref.name)
+		if ok {
+			if l.debug {
+				log.Printf("Resolved image stream tag %s/%s to %s\n", ref.namespace, ref.name, image)
+			}
+			l.addImage(images, image, ref.source)
+		} else if len(ref.fallback) > 0 {
+			log.Printf("Warning: cannot resolve image stream tag %s/%s referenced in %s, using %s\n", ref.namespace, ref.name, ref.location, ref.fallback)
+			l.addImage(images, ref.fallback, ref.source)
+		} else {
+			log.Printf("Warning: cannot resolve image stream tag %s/%s referenced in %s\n", ref.namespace, ref.name, ref.location)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestImageStreamsResolve(t *testing.T) {
+	streams := newImageStreams()
+	streams.addTag("apps", "nginx", "1.25", corev1.ObjectReference{Kind: "DockerImage", Name: "nginx:1.25"})
+	streams.addTag("apps", "nginx", "stable", corev1.ObjectReference{Kind: "ImageStreamTag", Name: "1.25"})
+	streams.addTag("apps", "nginx", "latest", corev1.ObjectReference{Kind: "ImageStreamTag", Name: "nginx:stable"})
+	streams.addTag("web", "proxy", "1.0", corev1.ObjectReference{Kind: "ImageStreamTag", Name: "nginx:stable", Namespace: "apps"})
+	streams.addTag("apps", "loop", "a", corev1.ObjectReference{Kind: "ImageStreamTag", Name: "b"})
+	streams.addTag("apps", "loop", "b", corev1.ObjectReference{Kind: "ImageStreamTag", Name: "a"})
+	streams.addTag("apps", "image", "1.0", corev1.ObjectReference{Kind: "ImageStreamImage", Name: "image@sha256:0123"})
+	tests := []struct {
+		name      string
+		namespace string
+		tag       string
+		expected  string
+	}{
+		{
+			name:      "docker image",
+			namespace: "apps",
+			tag:       "nginx:1.25",
+			expected:  "nginx:1.25",
+		},
+		{
+			name:      "tag of the same image stream",
+			namespace: "apps",
+			tag:       "nginx:stable",
+			expected:  "nginx:1.25",
+		},
+		{
+			name:      "latest tag by default",
+			namespace: "apps",
+			tag:       "nginx",
+			expected:  "nginx:1.25",
+		},
+		{
+			name:      "tag of another namespace",
+			namespace: "web",
+			tag:       "proxy:1.0",
+			expected:  "nginx:1.25",
+		},
+		{
+			name:      "unknown namespace",
+			namespace: "web",
+			tag:       "nginx:1.25",
+		},
+		{
+			name:      "unknown tag",
+			namespace: "apps",
+			tag:       "nginx:1.24",
+		},
+		{
+			name:      "loop",
+			namespace: "apps",
+			tag:       "loop:a",
+		},
+		{
+			name:      "unsupported kind",
+			namespace: "apps",
+			tag:       "image:1.0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			image, ok := streams.resolve(test.namespace, test.tag)
+			if ok != (len(test.expected) > 0) || image != test.expected {
+				t.Errorf("expected %q, got %q (%t)", test.expected, image, ok)
+			}
+		})
+	}
+}
+
+func TestOpenShiftImages(t *testing.T) {
+	imageStream := `apiVersion: image.openshift.io/v1
+kind: ImageStream
+metadata:
+  name: app
+spec:
+  tags:
+  - name: "1.0"
+    from:
+      kind: DockerImage
+      name: registry.example.com/app:1.0
+  - name: stable
+    from:
+      kind: ImageStreamTag
+      name: "1.0"
+`
+	deploymentConfig := `apiVersion: apps.openshift.io/v1
+kind: DeploymentConfig
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: " "
+      - name: sidecar
+        image: envoy:v1.26
+      initContainers:
+      - name: init
+        image: busybox:1.36
+  triggers:
+  - type: ImageChange
+    imageChangeParams:
+      containerNames:
+      - app
+      from:
+        kind: ImageStreamTag
+        name: app:stable
+  - type: ImageChange
+    imageChangeParams:
+      containerNames:
+      - init
+      from:
+        kind: ImageStreamTag
+        name: tools:1.0
+`
+	tests := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name:     "image stream",
+			content:  imageStream,
+			expected: []string{"registry.example.com/app:1.0"},
+		},
+		{
+			name: "image stream tag",
+			content: `apiVersion: image.openshift.io/v1
+kind: ImageStreamTag
+metadata:
+  name: app:1.0
+tag:
+  from:
+    kind: DockerImage
+    name: registry.example.com/app:1.0
+`,
+			expected: []string{"registry.example.com/app:1.0"},
+		},
+		{
+			name:     "deployment config triggered by a tag of the chart, or by an unknown tag",
+			content:  deploymentConfig + "---\n" + imageStream,
+			expected: []string{"busybox:1.36", "envoy:v1.26", "registry.example.com/app:1.0"},
+		},
+		{
+			name:     "deployment config triggered by a tag defined outside the chart",
+			content:  deploymentConfig,
+			expected: []string{"busybox:1.36", "envoy:v1.26"},
+		},
+		{
+			name: "build config",
+			content: `apiVersion: build.openshift.io/v1
+kind: BuildConfig
+metadata:
+  name: app
+spec:
+  source:
+    images:
+    - from:
+        kind: DockerImage
+        name: golang:1.20
+  strategy:
+    dockerStrategy:
+      from:
+        kind: ImageStreamTag
+        name: app:stable
+  triggers:
+  - type: ImageChange
+    imageChange:
+      from:
+        kind: DockerImage
+        name: alpine:3.18
+---
+` + imageStream,
+			expected: []string{"alpine:3.18", "golang:1.20", "registry.example.com/app:1.0"},
+		},
+		{
+			name: "legacy api version",
+			content: `apiVersion: v1
+kind: DeploymentConfig
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.25
+`,
+			expected: []string{"nginx:1.25"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := testListCmd(t)
+			l.namespace = "default"
+			images := newImagesList(refStyleAsRendered)
+			err := l.addManifestImages(images, "chart/templates/test.yaml", []byte(test.content), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.resolveImageStreams(images)
+			found := images.get()
+			sort.Strings(found)
+			if !reflect.DeepEqual(found, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, found)
+			}
+		})
+	}
+}
+
+func writeTestChart(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		fileName := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(fileName), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(fileName, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestImageStreamsOfProfiles(t *testing.T) {
+	dir := writeTestChart(t, map[string]string{
+		"chart/Chart.yaml": `apiVersion: v2
+name: chart
+version: 0.1.0
+`,
+		"chart/templates/openshift.yaml": `{{- if .Values.image }}
+apiVersion: image.openshift.io/v1
+kind: ImageStream
+metadata:
+  name: app
+spec:
+  tags:
+  - name: stable
+    from:
+      kind: DockerImage
+      name: {{ .Values.image }}
+---
+{{- end }}
+apiVersion: apps.openshift.io/v1
+kind: DeploymentConfig
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: " "
+  triggers:
+  - type: ImageChange
+    imageChangeParams:
+      containerNames:
+      - app
+      from:
+        kind: ImageStreamTag
+        name: app:stable
+`,
+		"with-stream.yaml":    "image: registry.example.com/app:1.0\n",
+		"without-stream.yaml": "image: \"\"\n",
+	})
+	l := &listCmd{
+		chartName: filepath.Join(dir, "chart"),
+		namespace: "default",
+		profiles: []profile{
+			{name: "with-stream", valueFiles: []string{filepath.Join(dir, "with-stream.yaml")}},
+			{name: "without-stream", valueFiles: []string{filepath.Join(dir, "without-stream.yaml")}},
+		},
+	}
+	images, err := l.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"registry.example.com/app:1.0"}
+	if found := images.get(); !reflect.DeepEqual(found, expected) {
+		t.Fatalf("expected %v, got %v", expected, found)
+	}
+	// the deployment config of the profile without image stream is not resolved with the one of the other profile
+	for _, source := range images.getSources("registry.example.com/app:1.0") {
+		if source.Profile != "with-stream" {
+			t.Errorf("unexpected source %v", source)
+		}
+	}
+}
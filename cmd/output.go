@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputCSV   = "csv"
+	outputTable = "table"
+)
+
+var outputFormats = []string{outputText, outputJSON, outputYAML, outputCSV, outputTable}
+
+type imageOutput struct {
+	Image     string        `json:"image"`
+	Heuristic bool          `json:"heuristic,omitempty"`
+	Profiles  []string      `json:"profiles,omitempty"`
+	Sources   []imageSource `json:"sources,omitempty"`
+	Digest    string        `json:"digest,omitempty"`
+	MediaType string        `json:"mediaType,omitempty"`
+	Index     bool          `json:"index,omitempty"`
+}
+
+// pinned returns the image reference followed by its resolved digest, if any
+func (o imageOutput) pinned() string {
+	if len(o.Digest) == 0 || strings.Contains(o.Image, "@") {
+		return o.Image
+	}
+	return o.Image + "@" + o.Digest
+}
+
+func checkOutputFormat(output string, format string) error {
+	if len(format) > 0 && output != outputText {
+		return fmt.Errorf("--format cannot be used with --output %s", output)
+	}
+	for _, f := range outputFormats {
+		if output == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid output format %q, shall be one of %s", output, strings.Join(outputFormats, ", "))
+}
+
+func newImagesOutput(images *imagesList) []imageOutput {
+	var output []imageOutput
+	for _, image := range images.get() {
+		sources := images.getSources(image)
+		imageOutput := imageOutput{
+			Image:     image,
+			Heuristic: images.isHeuristic(image),
+			Profiles:  imageProfiles(sources),
+			Sources:   sources,
+		}
+		if resolved := images.getResolved(image); resolved != nil {
+			imageOutput.Digest = resolved.Digest.String()
+			imageOutput.MediaType = resolved.MediaType
+			imageOutput.Index = resolved.Index
+		}
+		output = append(output, imageOutput)
+	}
+	return output
+}
+
+func resourceName(source imageSource) string {
+	if len(source.Kind) == 0 {
+		return ""
+	}
+	if len(source.Namespace) > 0 {
+		return source.Kind + " " + source.Namespace + "/" + source.Name
+	}
+	return source.Kind + " " + source.Name
+}
+
+func writeText(out io.Writer, images []imageOutput, showSources bool) error {
+	for _, image := range images {
+		line := image.pinned()
+		if len(image.MediaType) > 0 {
+			line = line + " " + image.MediaType
+		}
+		if image.Index {
+			line = line + " (multi-arch)"
+		}
+		if image.Heuristic {
+			line = line + " (heuristic)"
+		}
+		if len(image.Profiles) > 0 {
+			line = line + " [" + strings.Join(image.Profiles, ", ") + "]"
+		}
+		fmt.Fprintln(out, line)
+		if showSources {
+			for _, source := range image.Sources {
+				fmt.Fprintf(out, "  %s\n", source)
+			}
+		}
+	}
+	return nil
+}
+
+func writeTemplate(out io.Writer, images []imageOutput, format string) error {
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return fmt.Errorf("parsing format: %w", err)
+	}
+	for _, image := range images {
+		err = tmpl.Execute(out, image)
+		if err != nil {
+			return fmt.Errorf("executing format: %w", err)
+		}
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
+func writeCSV(out io.Writer, images []imageOutput) error {
+	w := csv.NewWriter(out)
+	err := w.Write([]string{"image", "heuristic", "chart", "template", "kind", "namespace", "name", "container", "init", "ephemeral", "digest", "mediaType", "index", "profile"})
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		for _, source := range image.Sources {
+			err = w.Write([]string{image.Image, strconv.FormatBool(source.Heuristic), source.Chart, source.Template, source.Kind, source.Namespace, source.Name, source.Container, strconv.FormatBool(source.Init), strconv.FormatBool(source.Ephemeral), image.Digest, image.MediaType, strconv.FormatBool(image.Index), source.Profile})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeTable(out io.Writer, images []imageOutput) error {
+	withProfiles := false
+	for _, image := range images {
+		if len(image.Profiles) > 0 {
+			withProfiles = true
+		}
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if withProfiles {
+		fmt.Fprintln(w, "IMAGE\tPROFILE\tCHART\tRESOURCE\tCONTAINER")
+	} else {
+		fmt.Fprintln(w, "IMAGE\tCHART\tRESOURCE\tCONTAINER")
+	}
+	for _, image := range images {
+		name := image.pinned()
+		if image.Heuristic {
+			name = name + " (heuristic)"
+		}
+		for _, source := range image.Sources {
+			container := source.Container
+			switch {
+			case source.Init:
+				container = container + " (init)"
+			case source.Ephemeral:
+				container = container + " (ephemeral)"
+			}
+			if withProfiles {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, source.Profile, source.Chart, resourceName(source), container)
+			} else {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, source.Chart, resourceName(source), container)
+			}
+			name = ""
+		}
+	}
+	return w.Flush()
+}
+
+// writeImages writes the images list in the requested output format, or with the given Go template
+func writeImages(out io.Writer, images *imagesList, output string, format string, showSources bool) error {
+	imagesOutput := newImagesOutput(images)
+	if len(format) > 0 {
+		return writeTemplate(out, imagesOutput, format)
+	}
+	switch output {
+	case outputJSON:
+		if imagesOutput == nil {
+			imagesOutput = []imageOutput{}
+		}
+		data, err := json.MarshalIndent(imagesOutput, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case outputYAML:
+		if imagesOutput == nil {
+			imagesOutput = []imageOutput{}
+		}
+		data, err := yaml.Marshal(imagesOutput)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	case outputCSV:
+		return writeCSV(out, imagesOutput)
+	case outputTable:
+		return writeTable(out, imagesOutput)
+	default:
+		return writeText(out, imagesOutput, showSources)
+	}
+}
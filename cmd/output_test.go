@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/gemalto/helm-image/internal/containerd"
+	"strings"
+	"testing"
+)
+
+func TestCheckOutputFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		format string
+		err    string
+	}{
+		{
+			name:   "text",
+			output: outputText,
+		},
+		{
+			name:   "table",
+			output: outputTable,
+		},
+		{
+			name:   "format with text",
+			output: outputText,
+			format: "{{.Image}}",
+		},
+		{
+			name:   "format with json",
+			output: outputJSON,
+			format: "{{.Image}}",
+			err:    "--format cannot be used with --output json",
+		},
+		{
+			name:   "invalid output",
+			output: "xml",
+			err:    `invalid output format "xml"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkOutputFormat(test.output, test.format)
+			if len(test.err) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func testImagesList() *imagesList {
+	images := newImagesList(refStyleAsRendered)
+	images.add("nginx:1.25", imageSource{Chart: "app", Template: "app/templates/web.yaml", Kind: "Deployment", Namespace: "prod", Name: "web", Container: "nginx"})
+	images.add("busybox:1.36", imageSource{Chart: "app", Template: "app/templates/web.yaml", Kind: "Deployment", Namespace: "prod", Name: "web", Container: "init", Init: true})
+	images.add("alpine:3.18", imageSource{Chart: "app", Template: "app/templates/debug.yaml", Heuristic: true})
+	images.add("nginx:1.25", imageSource{Chart: "app", Template: "app/templates/api.yaml", Kind: "Deployment", Name: "api", Container: "proxy"})
+	images.setResolved("nginx:1.25", &containerd.ResolvedImage{Digest: "sha256:0123", MediaType: "application/vnd.oci.image.index.v1+json", Index: true})
+	return images
+}
+
+func TestWriteImages(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		format      string
+		showSources bool
+		expected    string
+	}{
+		{
+			name:   "text sorted by image",
+			output: outputText,
+			expected: `alpine:3.18 (heuristic)
+busybox:1.36
+nginx:1.25@sha256:0123 application/vnd.oci.image.index.v1+json (multi-arch)
+`,
+		},
+		{
+			name:        "text with sources sorted",
+			output:      outputText,
+			showSources: true,
+			expected: `alpine:3.18 (heuristic)
+  app/templates/debug.yaml (heuristic)
+busybox:1.36
+  app/templates/web.yaml: Deployment prod/web, init container init
+nginx:1.25@sha256:0123 application/vnd.oci.image.index.v1+json (multi-arch)
+  app/templates/api.yaml: Deployment api, container proxy
+  app/templates/web.yaml: Deployment prod/web, container nginx
+`,
+		},
+		{
+			name:   "template",
+			output: outputText,
+			format: "{{.Image}} {{len .Sources}}",
+			expected: `alpine:3.18 1
+busybox:1.36 1
+nginx:1.25 2
+`,
+		},
+		{
+			name:   "csv with one line per source",
+			output: outputCSV,
+			expected: `image,heuristic,chart,template,kind,namespace,name,container,init,ephemeral,digest,mediaType,index,profile
+alpine:3.18,true,app,app/templates/debug.yaml,,,,,false,false,,,false,
+busybox:1.36,false,app,app/templates/web.yaml,Deployment,prod,web,init,true,false,,,false,
+nginx:1.25,false,app,app/templates/api.yaml,Deployment,,api,proxy,false,false,sha256:0123,application/vnd.oci.image.index.v1+json,true,
+nginx:1.25,false,app,app/templates/web.yaml,Deployment,prod,web,nginx,false,false,sha256:0123,application/vnd.oci.image.index.v1+json,true,
+`,
+		},
+		{
+			name:   "table",
+			output: outputTable,
+			expected: "IMAGE                    CHART  RESOURCE             CONTAINER\n" +
+				"alpine:3.18 (heuristic)  app                         \n" +
+				"busybox:1.36             app    Deployment prod/web  init (init)\n" +
+				"nginx:1.25@sha256:0123   app    Deployment api       proxy\n" +
+				"                         app    Deployment prod/web  nginx\n",
+		},
+		{
+			name:   "yaml",
+			output: outputYAML,
+			expected: `- heuristic: true
+  image: alpine:3.18
+  sources:
+  - chart: app
+    heuristic: true
+    template: app/templates/debug.yaml
+- image: busybox:1.36
+  sources:
+  - chart: app
+    container: init
+    init: true
+    kind: Deployment
+    name: web
+    namespace: prod
+    template: app/templates/web.yaml
+- digest: sha256:0123
+  image: nginx:1.25
+  index: true
+  mediaType: application/vnd.oci.image.index.v1+json
+  sources:
+  - chart: app
+    container: proxy
+    kind: Deployment
+    name: api
+    template: app/templates/api.yaml
+  - chart: app
+    container: nginx
+    kind: Deployment
+    name: web
+    namespace: prod
+    template: app/templates/web.yaml
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := writeImages(&out, testImagesList(), test.output, test.format, test.showSources)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestWriteImagesEmptyJSON(t *testing.T) {
+	var out bytes.Buffer
+	err := writeImages(&out, newImagesList(refStyleAsRendered), outputJSON, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "[]\n" {
+		t.Errorf("expected an empty JSON array, got %q", out.String())
+	}
+}
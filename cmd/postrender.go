@@ -0,0 +1,393 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/docker/distribution/reference"
+	"github.com/gemalto/helm-image/internal/registry"
+	"github.com/gemalto/helm-image/internal/rules"
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/cobra"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type postRenderCmd struct {
+	namespace    string
+	registryName string
+	pathMappings []string
+	lockFile     string
+	excludes     []string
+	rulesFiles   []string
+	heuristic    bool
+	verbose      bool
+	debug        bool
+}
+
+func newPostRenderCmd(out io.Writer) *cobra.Command {
+	p := &postRenderCmd{}
+
+	cmd := &cobra.Command{
+		Use:          "postrender",
+		Short:        "rewrite docker images of rendered manifests to a mirror registry, as a helm post-renderer",
+		Long:         "read rendered manifests on stdin, rewrite the docker images they reference to a mirror registry and write them on stdout, as a helm post-renderer",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(p.registryName) == 0 {
+				return fmt.Errorf("--registry is required")
+			}
+			return p.postRender(cmd.InOrStdin(), out)
+		},
+	}
+
+	flags := cmd.Flags()
+
+	flags.StringVar(&p.registryName, "registry", "", "mirror registry (optionally followed by a path prefix) where images are pulled from, e.g. mirror.local:5000")
+	flags.StringSliceVar(&p.pathMappings, "map", []string{}, "relocate the repositories starting with a prefix under another path of the registry, as from=to (can specify multiple)")
+	flags.StringVar(&p.lockFile, "lock", "", "pin images to the digests given in the lock file (see lock command)")
+	flags.StringSliceVarP(&p.excludes, "exclude", "x", []string{}, "specify docker images to be left unchanged")
+	flags.StringSliceVar(&p.rulesFiles, "rules", []string{}, "specify image extraction rules for custom resources in a YAML file (can specify multiple)")
+	flags.BoolVar(&p.heuristic, "heuristic", true, "search for containers at any depth of resources which are neither known nor matched by a rule")
+	flags.BoolVarP(&p.verbose, "verbose", "v", false, "enable verbose output")
+
+	// When called through helm, debug mode is transmitted through the HELM_DEBUG envvar
+	helmDebug := os.Getenv("HELM_DEBUG")
+	if helmDebug == "1" || strings.EqualFold(helmDebug, "true") || strings.EqualFold(helmDebug, "on") {
+		p.debug = true
+	}
+
+	// When called through helm, namespace is transmitted through the HELM_NAMESPACE envvar
+	namespace := os.Getenv("HELM_NAMESPACE")
+	if len(namespace) > 0 {
+		p.namespace = namespace
+	} else {
+		p.namespace = "default"
+	}
+
+	return cmd
+}
+
+// templateOfDocument returns the template a document has been rendered from, given by helm in a "# Source:" comment
+func templateOfDocument(document []byte) string {
+	for _, line := range strings.Split(string(document), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "# Source: ") {
+			return strings.TrimPrefix(line, "# Source: ")
+		}
+	}
+	return ""
+}
+
+// readDocuments splits a stream of manifests in YAML documents
+func readDocuments(in io.Reader) ([][]byte, error) {
+	var documents [][]byte
+	reader := yaml.NewYAMLReader(bufio.NewReader(in))
+	for index := 1; ; index++ {
+		document, err := reader.Read()
+		if err == io.EOF {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading document %d: %w", index, err)
+		}
+		documents = append(documents, document)
+	}
+}
+
+// imageNodeToken prefixes the tokens replacing the images of a document in its shadow value
+const imageNodeToken = "\x00image-node-"
+
+// shadowValue returns the unstructured value of a YAML node, the strings which are known images being replaced by
+// tokens giving the index of their node, so that the fields where images are found can be mapped back to their nodes
+func shadowValue(node *yamlv3.Node, mirrorImages map[string]string, nodes *[]*yamlv3.Node) interface{} {
+	switch node.Kind {
+	case yamlv3.DocumentNode:
+		if len(node.Content) > 0 {
+			return shadowValue(node.Content[0], mirrorImages, nodes)
+		}
+	case yamlv3.AliasNode:
+		return shadowValue(node.Alias, mirrorImages, nodes)
+	case yamlv3.MappingNode:
+		values := map[string]interface{}{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			values[node.Content[i].Value] = shadowValue(node.Content[i+1], mirrorImages, nodes)
+		}
+		return values
+	case yamlv3.SequenceNode:
+		values := []interface{}{}
+		for _, child := range node.Content {
+			values = append(values, shadowValue(child, mirrorImages, nodes))
+		}
+		return values
+	case yamlv3.ScalarNode:
+		if _, ok := mirrorImages[node.Value]; ok && node.Tag == "!!str" {
+			*nodes = append(*nodes, node)
+			return imageNodeToken + strconv.Itoa(len(*nodes)-1)
+		}
+		var value interface{}
+		if err := node.Decode(&value); err == nil {
+			return value
+		}
+		return node.Value
+	}
+	return nil
+}
+
+// findDockerImageNames returns the names of the docker image references found at any depth, like the from
+// references of OpenShift resources
+func findDockerImageNames(node interface{}) []string {
+	var names []string
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if kind, ok := n["kind"].(string); ok && kind == "DockerImage" {
+			if name, ok := n["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+		for _, value := range n {
+			names = append(names, findDockerImageNames(value)...)
+		}
+	case []interface{}:
+		for _, value := range n {
+			names = append(names, findDockerImageNames(value)...)
+		}
+	}
+	return names
+}
+
+// imageFields returns the values of the fields of a resource where images are found, like addDocumentImages does:
+// containers and docker image references of resources known by the scheme, fields given by the matching rules,
+// and containers found heuristically in other resources
+func (l *listCmd) imageFields(object map[string]interface{}) ([]string, error) {
+	apiVersion, _ := object["apiVersion"].(string)
+	kind, _ := object["kind"].(string)
+	if items, ok := object["items"].([]interface{}); ok && kind == "List" {
+		var values []string
+		for _, item := range items {
+			if itemObject, ok := item.(map[string]interface{}); ok {
+				itemValues, err := l.imageFields(itemObject)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, itemValues...)
+			}
+		}
+		return values, nil
+	}
+	var values []string
+	registered := scheme.Scheme.Recognizes(schema.FromAPIVersionAndKind(apiVersion, kind))
+	if registered {
+		for _, container := range findContainers(object) {
+			values = append(values, container.image)
+		}
+		values = append(values, findDockerImageNames(object)...)
+	}
+	matchingRules := l.rules.Match(apiVersion, kind)
+	for _, rule := range matchingRules {
+		for _, ruleImage := range rule.Images {
+			// images built from a repository and a tag found in distinct fields cannot be rewritten
+			if len(ruleImage.Path) == 0 {
+				continue
+			}
+			found, err := ruleImage.Images(object)
+			if err != nil {
+				return nil, fmt.Errorf("applying rules for %s %s: %w", rule.APIVersion, rule.Kind, err)
+			}
+			values = append(values, found...)
+		}
+	}
+	if !registered && len(matchingRules) == 0 && l.heuristic {
+		for _, container := range findContainers(object) {
+			values = append(values, container.image)
+		}
+	}
+	return values, nil
+}
+
+// rewriteDocument returns a document with its images replaced by their mirror images, in the fields where images
+// are found only, unchanged documents being returned as is to keep their formatting
+func (l *listCmd) rewriteDocument(document []byte, mirrorImages map[string]string) ([]byte, []string, error) {
+	var node yamlv3.Node
+	err := yamlv3.Unmarshal(document, &node)
+	if err != nil {
+		return nil, nil, err
+	}
+	var nodes []*yamlv3.Node
+	object, ok := shadowValue(&node, mirrorImages, &nodes).(map[string]interface{})
+	if !ok || len(nodes) == 0 {
+		return document, nil, nil
+	}
+	values, err := l.imageFields(object)
+	if err != nil {
+		return nil, nil, err
+	}
+	var replaced []string
+	for _, value := range values {
+		if !strings.HasPrefix(value, imageNodeToken) {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(value, imageNodeToken))
+		if err != nil || index >= len(nodes) {
+			continue
+		}
+		// a node found by several fields, like a container matched by a rule, is only rewritten once
+		if mirrorImage, ok := mirrorImages[nodes[index].Value]; ok {
+			replaced = append(replaced, nodes[index].Value)
+			nodes[index].Value = mirrorImage
+		}
+	}
+	if len(replaced) == 0 {
+		return document, nil, nil
+	}
+	var b bytes.Buffer
+	encoder := yamlv3.NewEncoder(&b)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&node)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	return b.Bytes(), replaced, nil
+}
+
+// mirrorImages returns the mirror image of every image as rendered in the manifests, pinned to its locked digest if requested
+func (p *postRenderCmd) mirrorImages(images *imagesList) (map[string]string, error) {
+	pathMappings, err := registry.ParsePathMappings(p.pathMappings)
+	if err != nil {
+		return nil, err
+	}
+	var lock *imagesLock
+	if len(p.lockFile) > 0 {
+		lock, err = readLock(p.lockFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	excluded := map[string]struct{}{}
+	for _, image := range p.excludes {
+		excluded[normalizedImage(image)] = struct{}{}
+	}
+	mirrorImages := map[string]string{}
+	for name, info := range images.images {
+		if _, ok := excluded[name]; ok {
+			continue
+		}
+		mirrorRef, err := registry.MirrorImageRef(info.ref, p.registryName, pathMappings)
+		if err != nil {
+			return nil, err
+		}
+		if lock != nil {
+			if _, ok := mirrorRef.(reference.Digested); !ok {
+				locked := lock.find(name)
+				if locked == nil {
+					return nil, fmt.Errorf("image %s is not pinned in %s, please update it with helm image lock", name, p.lockFile)
+				}
+				mirrorRef, err = reference.WithDigest(mirrorRef, digest.Digest(locked.Digest))
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		for _, rendered := range info.rendered {
+			mirrorImages[rendered] = mirrorRef.String()
+		}
+	}
+	return mirrorImages, nil
+}
+
+func (p *postRenderCmd) postRender(in io.Reader, out io.Writer) error {
+	// images are found exactly like for the other commands, so that the images rewritten are the ones saved
+	l := &listCmd{
+		namespace: p.namespace,
+		heuristic: p.heuristic,
+		refStyle:  refStyleAsRendered,
+		verbose:   p.verbose,
+		debug:     p.debug,
+	}
+	var err error
+	l.rules, err = rules.Load(p.rulesFiles)
+	if err != nil {
+		return err
+	}
+	documents, err := readDocuments(in)
+	if err != nil {
+		return err
+	}
+	images := newImagesList(refStyleAsRendered)
+	for i, document := range documents {
+		if isEmptyDocument(document) {
+			continue
+		}
+		location := fmt.Sprintf("document %d of stdin", i+1)
+		source := imageSource{
+			Template: location,
+		}
+		if template := templateOfDocument(document); len(template) > 0 {
+			source.Chart = chartOfTemplate(template)
+			source.Template = template
+		}
+		err = l.addDocumentImages(images, document, location, source)
+		if err != nil {
+			return err
+		}
+	}
+	l.resolveImageStreams(images)
+	invalid := images.getInvalid()
+	if len(invalid) > 0 {
+		for image, sources := range invalid {
+			for _, source := range sources {
+				log.Printf("Error: invalid image reference %q found in %s\n", image, source)
+			}
+		}
+		return fmt.Errorf("found %d invalid image references", len(invalid))
+	}
+
+	mirrorImages, err := p.mirrorImages(images)
+	if err != nil {
+		return err
+	}
+	rewritten := map[string]struct{}{}
+	for i, document := range documents {
+		if isEmptyDocument(document) {
+			continue
+		}
+		document, replaced, err := l.rewriteDocument(document, mirrorImages)
+		if err != nil {
+			return fmt.Errorf("rewriting document %d of stdin: %w", i+1, err)
+		}
+		for _, image := range replaced {
+			if p.verbose {
+				fmt.Fprintf(os.Stderr, "Rewriting %s as %s\n", image, mirrorImages[image])
+			}
+			rewritten[image] = struct{}{}
+		}
+		_, err = fmt.Fprintf(out, "---\n%s", document)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(document, []byte("\n")) {
+			fmt.Fprintln(out)
+		}
+	}
+	for image := range mirrorImages {
+		if _, ok := rewritten[image]; !ok {
+			// images built from a repository and a tag found in distinct fields by a rule cannot be rewritten
+			for _, source := range images.getSources(image) {
+				log.Printf("Warning: cannot rewrite image %s found in %s\n", image, source)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRewriteDocument(t *testing.T) {
+	mirrorImages := map[string]string{
+		"nginx:1.25":                            "mirror.local:5000/library/nginx:1.25",
+		"busybox:1.36":                          "mirror.local:5000/library/busybox:1.36",
+		"registry.example.com/app:1.0":          "mirror.local:5000/app:1.0",
+		"quay.io/prometheus/prometheus:v2.45.0": "mirror.local:5000/prometheus/prometheus:v2.45.0",
+	}
+	tests := []struct {
+		name      string
+		content   string
+		heuristic bool
+		expected  string
+		replaced  []string
+	}{
+		{
+			name: "deployment",
+			content: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  annotations:
+    image: nginx:1.25
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.25
+        env:
+        - name: IMAGE
+          value: busybox:1.36
+      initContainers:
+      - name: init
+        image: busybox:1.36
+`,
+			expected: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  annotations:
+    image: nginx:1.25
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          image: mirror.local:5000/library/nginx:1.25
+          env:
+            - name: IMAGE
+              value: busybox:1.36
+      initContainers:
+        - name: init
+          image: mirror.local:5000/library/busybox:1.36
+`,
+			replaced: []string{"busybox:1.36", "nginx:1.25"},
+		},
+		{
+			name: "config map with image keys left alone",
+			content: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  image: nginx:1.25
+  sidecarImage: busybox:1.36
+`,
+			expected: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  image: nginx:1.25
+  sidecarImage: busybox:1.36
+`,
+		},
+		{
+			name: "several documents",
+			content: `apiVersion: image.openshift.io/v1
+kind: ImageStream
+metadata:
+  name: app
+spec:
+  tags:
+  - name: "1.0"
+    from:
+      kind: DockerImage
+      name: registry.example.com/app:1.0
+---
+apiVersion: monitoring.coreos.com/v1
+kind: Prometheus
+metadata:
+  name: prometheus
+spec:
+  image: quay.io/prometheus/prometheus:v2.45.0
+  podMetadata:
+    labels:
+      image: nginx:1.25
+---
+apiVersion: example.com/v1
+kind: App
+metadata:
+  name: app
+spec:
+  image: nginx:1.25
+  template:
+    containers:
+    - name: app
+      image: nginx:1.25
+`,
+			heuristic: true,
+			expected: `apiVersion: image.openshift.io/v1
+kind: ImageStream
+metadata:
+  name: app
+spec:
+  tags:
+    - name: "1.0"
+      from:
+        kind: DockerImage
+        name: mirror.local:5000/app:1.0
+---
+apiVersion: monitoring.coreos.com/v1
+kind: Prometheus
+metadata:
+  name: prometheus
+spec:
+  image: mirror.local:5000/prometheus/prometheus:v2.45.0
+  podMetadata:
+    labels:
+      image: nginx:1.25
+---
+apiVersion: example.com/v1
+kind: App
+metadata:
+  name: app
+spec:
+  image: nginx:1.25
+  template:
+    containers:
+      - name: app
+        image: mirror.local:5000/library/nginx:1.25
+`,
+			replaced: []string{"nginx:1.25", "quay.io/prometheus/prometheus:v2.45.0", "registry.example.com/app:1.0"},
+		},
+		{
+			name: "heuristic disabled",
+			content: `apiVersion: example.com/v1
+kind: App
+metadata:
+  name: app
+spec:
+  containers:
+  - name: app
+    image: nginx:1.25
+`,
+			expected: `apiVersion: example.com/v1
+kind: App
+metadata:
+  name: app
+spec:
+  containers:
+  - name: app
+    image: nginx:1.25
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := testListCmd(t)
+			l.heuristic = test.heuristic
+			documents, err := readDocuments(strings.NewReader(test.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rewritten []string
+			var replaced []string
+			for _, document := range documents {
+				document, documentReplaced, err := l.rewriteDocument(document, mirrorImages)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rewritten = append(rewritten, string(document))
+				replaced = append(replaced, documentReplaced...)
+			}
+			if output := strings.Join(rewritten, "---\n"); output != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, output)
+			}
+			sort.Strings(replaced)
+			if !reflect.DeepEqual(replaced, test.replaced) {
+				t.Errorf("expected replaced %v, got %v", test.replaced, replaced)
+			}
+		})
+	}
+}
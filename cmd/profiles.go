@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+// profile is a named set of values files, the chart being rendered once per profile
+type profile struct {
+	name       string
+	valueFiles []string
+}
+
+type profilesFile struct {
+	Profiles map[string][]string `json:"profiles"`
+}
+
+// parseProfiles returns the profiles given as name=file flags, followed by the ones defined in the profiles file,
+// values files of a profiles file being relative to its directory
+func parseProfiles(flags []string, fileName string) ([]profile, error) {
+	var profiles []profile
+	indexes := map[string]int{}
+	add := func(name string, valueFiles ...string) {
+		i, ok := indexes[name]
+		if !ok {
+			i = len(profiles)
+			indexes[name] = i
+			profiles = append(profiles, profile{name: name})
+		}
+		profiles[i].valueFiles = append(profiles[i].valueFiles, valueFiles...)
+	}
+	for _, flag := range flags {
+		parts := strings.SplitN(flag, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid profile %q, shall be name=file", flag)
+		}
+		add(parts[0], parts[1])
+	}
+	if len(fileName) == 0 {
+		return profiles, nil
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("reading profiles file: %w", err)
+	}
+	file := &profilesFile{}
+	err = yaml.UnmarshalStrict(content, file)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", fileName, err)
+	}
+	var names []string
+	for name := range file.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var valueFiles []string
+		for _, valueFile := range file.Profiles[name] {
+			if !filepath.IsAbs(valueFile) && !strings.Contains(valueFile, "://") {
+				valueFile = filepath.Join(filepath.Dir(fileName), valueFile)
+			}
+			valueFiles = append(valueFiles, valueFile)
+		}
+		add(name, valueFiles...)
+	}
+	return profiles, nil
+}
+
+// imageProfiles returns the profiles an image is needed by
+func imageProfiles(sources []imageSource) []string {
+	profiles := map[string]struct{}{}
+	for _, source := range sources {
+		if len(source.Profile) > 0 {
+			profiles[source.Profile] = struct{}{}
+		}
+	}
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
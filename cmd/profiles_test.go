@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProfiles(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "profiles.yaml")
+	secretsFileName := filepath.Join(t.TempDir(), "secrets.yaml")
+	err := os.WriteFile(fileName, []byte(`profiles:
+  prod:
+    - prod.yaml
+    - `+secretsFileName+`
+  dev:
+    - dev.yaml
+    - https://example.com/values.yaml
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalidFileName := filepath.Join(dir, "invalid.yaml")
+	err = os.WriteFile(invalidFileName, []byte("profile:\n  prod: [prod.yaml]\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		flags    []string
+		fileName string
+		expected []profile
+		err      string
+	}{
+		{
+			name: "no profile",
+		},
+		{
+			name:  "flags in order",
+			flags: []string{"prod=prod.yaml", "dev=dev.yaml", "prod=prod-eu.yaml"},
+			expected: []profile{
+				{name: "prod", valueFiles: []string{"prod.yaml", "prod-eu.yaml"}},
+				{name: "dev", valueFiles: []string{"dev.yaml"}},
+			},
+		},
+		{
+			name:  "value file with equal sign",
+			flags: []string{"prod=values=prod.yaml"},
+			expected: []profile{
+				{name: "prod", valueFiles: []string{"values=prod.yaml"}},
+			},
+		},
+		{
+			name:     "file sorted by name, relative to its directory",
+			fileName: fileName,
+			expected: []profile{
+				{name: "dev", valueFiles: []string{filepath.Join(dir, "dev.yaml"), "https://example.com/values.yaml"}},
+				{name: "prod", valueFiles: []string{filepath.Join(dir, "prod.yaml"), secretsFileName}},
+			},
+		},
+		{
+			name:     "flags followed by file",
+			flags:    []string{"prod=override.yaml", "test=test.yaml"},
+			fileName: fileName,
+			expected: []profile{
+				{name: "prod", valueFiles: []string{"override.yaml", filepath.Join(dir, "prod.yaml"), secretsFileName}},
+				{name: "test", valueFiles: []string{"test.yaml"}},
+				{name: "dev", valueFiles: []string{filepath.Join(dir, "dev.yaml"), "https://example.com/values.yaml"}},
+			},
+		},
+		{
+			name:  "flag without file",
+			flags: []string{"prod="},
+			err:   `invalid profile "prod=", shall be name=file`,
+		},
+		{
+			name:  "flag without name",
+			flags: []string{"prod.yaml"},
+			err:   `invalid profile "prod.yaml", shall be name=file`,
+		},
+		{
+			name:     "missing file",
+			fileName: filepath.Join(dir, "missing.yaml"),
+			err:      "reading profiles file",
+		},
+		{
+			name:     "invalid file",
+			fileName: invalidFileName,
+			err:      "parsing " + invalidFileName,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			profiles, err := parseProfiles(test.flags, test.fileName)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(profiles, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, profiles)
+			}
+		})
+	}
+}
+
+func TestImageProfiles(t *testing.T) {
+	sources := []imageSource{
+		{Profile: "prod", Template: "a.yaml"},
+		{Template: "b.yaml"},
+		{Profile: "dev", Template: "a.yaml"},
+		{Profile: "prod", Template: "b.yaml"},
+	}
+	expected := []string{"dev", "prod"}
+	if profiles := imageProfiles(sources); !reflect.DeepEqual(profiles, expected) {
+		t.Errorf("expected %v, got %v", expected, profiles)
+	}
+}
@@ -1,9 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"github.com/docker/distribution/reference"
+	"github.com/gemalto/helm-image/internal/containerd"
 	"github.com/gemalto/helm-image/internal/docker"
+	"github.com/gemalto/helm-image/internal/registry"
 	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/action"
 	cliValues "helm.sh/helm/v3/pkg/cli/values"
 	"io"
 	"os"
@@ -11,14 +16,21 @@ import (
 )
 
 type pullCmd struct {
-	chartName  string
-	namespace  string
-	excludes   []string
-	auths      []string
-	valuesOpts cliValues.Options
-	helmPath   string
-	verbose    bool
-	debug      bool
+	chartName     string
+	chartPathOpts action.ChartPathOptions
+	devel         bool
+	namespace     string
+	excludes      []string
+	auths         []string
+	valuesOpts    cliValues.Options
+	allSubcharts  bool
+	rulesFiles    []string
+	heuristic     bool
+	lockFile      string
+	platforms     []string
+	allPlatforms  bool
+	verbose       bool
+	debug         bool
 }
 
 func newPullCmd(out io.Writer) *cobra.Command {
@@ -40,15 +52,23 @@ func newPullCmd(out io.Writer) *cobra.Command {
 
 	flags.StringSliceVarP(&p.auths, "auth", "a", []string{}, "specify private registries which need authentication during pull")
 	flags.StringSliceVarP(&p.excludes, "exclude", "x", []string{}, "specify docker images to be excluded from pulls")
+	flags.StringVar(&p.chartPathOpts.Version, "version", "", "specify a version constraint for the chart version to use, latest version being used if not set")
+	flags.StringVar(&p.chartPathOpts.RepoURL, "repo", "", "chart repository url where to locate the requested chart")
+	flags.StringVar(&p.chartPathOpts.Username, "username", "", "chart repository username where to locate the requested chart")
+	flags.StringVar(&p.chartPathOpts.Password, "password", "", "chart repository password where to locate the requested chart")
+	flags.BoolVar(&p.devel, "devel", false, "use development versions, too (equivalent to version '>0.0.0-0'), ignored if --version is set")
 	flags.StringSliceVarP(&p.valuesOpts.ValueFiles, "values", "f", []string{}, "specify values in a YAML file or a URL (can specify multiple)")
 	flags.StringArrayVar(&p.valuesOpts.Values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
 	flags.StringArrayVar(&p.valuesOpts.StringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
 	flags.StringArrayVar(&p.valuesOpts.FileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	flags.BoolVar(&p.allSubcharts, "all-subcharts", false, "search all sub-charts, forcing their conditions and tags to true by groups of same weight")
+	flags.StringSliceVar(&p.rulesFiles, "rules", []string{}, "specify image extraction rules for custom resources in a YAML file (can specify multiple)")
+	flags.BoolVar(&p.heuristic, "heuristic", true, "search for containers at any depth of resources which are neither known nor matched by a rule")
+	flags.StringVar(&p.lockFile, "lock", "", "fetch the digests the images are pinned to in the given lock file (see lock command)")
+	flags.StringSliceVar(&p.platforms, "platform", []string{}, "pull images for the given platforms, e.g. linux/amd64,linux/arm64 (can specify multiple), platform of docker daemon being used if not set")
+	flags.BoolVar(&p.allPlatforms, "all-platforms", false, "pull images for all their platforms")
 	flags.BoolVarP(&p.verbose, "verbose", "v", false, "enable verbose output")
 
-	// When called through helm, helm path is transmitted through the HELM_BIN envvar
-	p.helmPath = os.Getenv("HELM_BIN")
-
 	// When called through helm, debug mode is transmitted through the HELM_DEBUG envvar
 	helmDebug := os.Getenv("HELM_DEBUG")
 	if helmDebug == "1" || strings.EqualFold(helmDebug, "true") || strings.EqualFold(helmDebug, "on") {
@@ -68,44 +88,96 @@ func newPullCmd(out io.Writer) *cobra.Command {
 
 func (p *pullCmd) pull() error {
 	l := &listCmd{
-		chartName:  p.chartName,
-		namespace:  p.namespace,
-		valuesOpts: p.valuesOpts,
-		helmPath:   p.helmPath,
-		debug:      p.debug,
-		verbose:    p.verbose,
+		chartName:     p.chartName,
+		chartPathOpts: p.chartPathOpts,
+		devel:         p.devel,
+		namespace:     p.namespace,
+		valuesOpts:    p.valuesOpts,
+		allSubcharts:  p.allSubcharts,
+		rulesFiles:    p.rulesFiles,
+		heuristic:     p.heuristic,
+		debug:         p.debug,
+		verbose:       p.verbose,
 	}
 	images, err := l.list()
-	includedImagesMap := map[string]struct{}{}
-	for _, image := range images {
-		includedImagesMap[image] = struct{}{}
-		for _, excludedImage := range p.excludes {
-			if image == excludedImage {
-				delete(includedImagesMap, image)
-			}
+	if err != nil {
+		return err
+	}
+	includedImages := excludeImages(images.get(), p.excludes)
+	if len(p.lockFile) > 0 {
+		includedImages, err = pinImages(includedImages, p.lockFile)
+		if err != nil {
+			return err
 		}
 	}
-	var includedImages []string
-	for image := range includedImagesMap {
-		includedImages = append(includedImages, image)
+	if len(p.platforms) == 0 && !p.allPlatforms {
+		for _, image := range includedImages {
+			if p.verbose {
+				fmt.Printf("Pulling %s...\n", image)
+			}
+			err = docker.Pull(image, "", l.debug)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
 	}
+	platforms, err := containerd.ParsePlatforms(p.platforms, p.allPlatforms)
 	if err != nil {
 		return err
 	}
-	//client, err := containerd.ClientWithAddress(os.Getenv("DOCKER_HOST"), l.debug)
-	//if err != nil {
-	//	return err
-	//}
-	//ctx := namespaces.WithNamespace(context.Background(), "default")
-	//for _, auth := range p.auths {
-	//	registry.AddAuthRegistry(auth)
-	//}
+	addAuthRegistries(p.auths, l.debug)
+	resolver := containerd.NewResolver(registry.ConsoleCredentials, false)
+	ctx := context.Background()
 	for _, image := range includedImages {
+		// docker pulls one platform at a time, so that the platforms of each image are queried first
+		resolved, err := containerd.ResolveImage(ctx, resolver, image)
+		if err != nil {
+			return err
+		}
+		platformImages, err := containerd.ResolvePlatforms(ctx, resolver, resolved)
+		if err != nil {
+			return err
+		}
+		if !resolved.Index {
+			if p.verbose {
+				fmt.Printf("Pulling %s, which has a single platform...\n", image)
+			}
+			err = docker.Pull(image, "", l.debug)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		platformImages, err = platforms.SelectPlatformImages(image, platformImages)
+		if err != nil {
+			return err
+		}
+		if len(platformImages) == 0 {
+			return fmt.Errorf("image %s has no manifest for %s", image, platforms)
+		}
+		imageRef, err := registry.ParseImageRef(image)
+		if err != nil {
+			return err
+		}
+		// docker keeps a single platform of an image under its name, the other platforms being kept under their digest
+		for _, platformImage := range platformImages[1:] {
+			digestRef, err := reference.WithDigest(reference.TrimNamed(imageRef), platformImage.Digest)
+			if err != nil {
+				return err
+			}
+			if p.verbose {
+				fmt.Printf("Pulling %s for %s...\n", digestRef, platformImage.Platform)
+			}
+			err = docker.Pull(digestRef.String(), platformImage.Platform, l.debug)
+			if err != nil {
+				return err
+			}
+		}
 		if p.verbose {
-			fmt.Printf("Pulling %s...\n", image)
+			fmt.Printf("Pulling %s for %s...\n", image, platformImages[0].Platform)
 		}
-		err = docker.Pull(image, l.debug)
-		//err = containerd.PullImage(ctx, client, registry.ConsoleCredentials, image, l.debug)
+		err = docker.Pull(image, platformImages[0].Platform, l.debug)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,362 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/docker/distribution/reference"
+	"github.com/gemalto/helm-image/internal/helm"
+	"github.com/gemalto/helm-image/internal/registry"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	cliValues "helm.sh/helm/v3/pkg/cli/values"
+	"io"
+	"log"
+	"os"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+// imageValue is a value of the chart producing an image, either a string or a map of registry, repository and tag
+type imageValue struct {
+	path        []string
+	value       string
+	ref         reference.Named
+	nameOnly    bool
+	hasRegistry bool
+}
+
+type relocateCmd struct {
+	chartName     string
+	chartPathOpts action.ChartPathOptions
+	devel         bool
+	namespace     string
+	registryName  string
+	pathMappings  []string
+	excludes      []string
+	valuesOpts    cliValues.Options
+	allSubcharts  bool
+	rulesFiles    []string
+	heuristic     bool
+	file          string
+	verbose       bool
+	debug         bool
+}
+
+func newRelocateCmd(out io.Writer) *cobra.Command {
+	r := &relocateCmd{}
+
+	cmd := &cobra.Command{
+		Use:          "relocate",
+		Short:        "generate the values relocating docker images referenced in a chart to a mirror registry",
+		Long:         "generate the values relocating docker images referenced in a chart to a mirror registry",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r.chartName = args[0]
+			if len(r.registryName) == 0 {
+				return fmt.Errorf("--registry is required")
+			}
+			return r.relocate(out)
+		},
+	}
+
+	flags := cmd.Flags()
+
+	flags.StringVar(&r.registryName, "registry", "", "mirror registry (optionally followed by a path prefix) where images are pulled from, e.g. mirror.local:5000")
+	flags.StringSliceVar(&r.pathMappings, "map", []string{}, "relocate the repositories starting with a prefix under another path of the registry, as from=to (can specify multiple)")
+	flags.StringSliceVarP(&r.excludes, "exclude", "x", []string{}, "specify docker images to be left unchanged")
+	flags.StringVar(&r.file, "file", "", "values file name where to write relocated values, stdout being used if not set")
+	flags.StringVar(&r.chartPathOpts.Version, "version", "", "specify a version constraint for the chart version to use, latest version being used if not set")
+	flags.StringVar(&r.chartPathOpts.RepoURL, "repo", "", "chart repository url where to locate the requested chart")
+	flags.StringVar(&r.chartPathOpts.Username, "username", "", "chart repository username where to locate the requested chart")
+	flags.StringVar(&r.chartPathOpts.Password, "password", "", "chart repository password where to locate the requested chart")
+	flags.BoolVar(&r.devel, "devel", false, "use development versions, too (equivalent to version '>0.0.0-0'), ignored if --version is set")
+	flags.StringSliceVarP(&r.valuesOpts.ValueFiles, "values", "f", []string{}, "specify values in a YAML file or a URL (can specify multiple)")
+	flags.StringArrayVar(&r.valuesOpts.Values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&r.valuesOpts.StringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	flags.StringArrayVar(&r.valuesOpts.FileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	flags.BoolVar(&r.allSubcharts, "all-subcharts", false, "search all sub-charts, forcing their conditions and tags to true by groups of same weight")
+	flags.StringSliceVar(&r.rulesFiles, "rules", []string{}, "specify image extraction rules for custom resources in a YAML file (can specify multiple)")
+	flags.BoolVar(&r.heuristic, "heuristic", true, "search for containers at any depth of resources which are neither known nor matched by a rule")
+	flags.BoolVarP(&r.verbose, "verbose", "v", false, "enable verbose output")
+
+	// When called through helm, debug mode is transmitted through the HELM_DEBUG envvar
+	helmDebug := os.Getenv("HELM_DEBUG")
+	if helmDebug == "1" || strings.EqualFold(helmDebug, "true") || strings.EqualFold(helmDebug, "on") {
+		r.debug = true
+	}
+
+	// When called through helm, namespace is transmitted through the HELM_NAMESPACE envvar
+	namespace := os.Getenv("HELM_NAMESPACE")
+	if len(namespace) > 0 {
+		r.namespace = namespace
+	} else {
+		r.namespace = "default"
+	}
+
+	return cmd
+}
+
+// valueString returns a scalar value as rendered by a template
+func valueString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// imageOfMap returns the image produced by the common shapes of image values, a map with a repository (or a name
+// along with a registry or a tag), an optional registry, tag and digest, and whether the map has a registry key
+func imageOfMap(values map[string]interface{}) (string, string, bool) {
+	_, hasRegistry := values["registry"]
+	_, hasTag := values["tag"]
+	repositoryKey := "repository"
+	repository, ok := values[repositoryKey].(string)
+	if !ok {
+		repositoryKey = "name"
+		repository, ok = values[repositoryKey].(string)
+		if !ok || (!hasRegistry && !hasTag) {
+			return "", "", false
+		}
+	}
+	if len(repository) == 0 {
+		return "", "", false
+	}
+	image := repository
+	if registryName := valueString(values["registry"]); len(registryName) > 0 {
+		image = registryName + "/" + image
+	}
+	if tag := valueString(values["tag"]); len(tag) > 0 {
+		image = image + ":" + tag
+	}
+	if digest := valueString(values["digest"]); len(digest) > 0 {
+		image = image + "@" + digest
+	}
+	return image, repositoryKey, hasRegistry
+}
+
+// matchImage returns the image produced by a value, values without tag matching the images of the same repository
+// as charts usually default tags to their app version
+func matchImage(value string, images map[string]reference.Named) (reference.Named, bool, bool) {
+	ref, err := registry.ParseImageRef(value)
+	if err != nil {
+		return nil, false, false
+	}
+	if matched, ok := images[ref.String()]; ok {
+		return matched, false, true
+	}
+	named, err := reference.ParseNormalizedNamed(value)
+	if err != nil || !reference.IsNameOnly(named) {
+		return nil, false, false
+	}
+	for _, matched := range images {
+		if matched.Name() == named.Name() {
+			return matched, true, true
+		}
+	}
+	return nil, false, false
+}
+
+// findImageValues walks the values of a chart and returns the values producing one of the given images: strings
+// of keys containing "image", and maps with a repository, an optional registry, tag and digest
+func findImageValues(values map[string]interface{}, path []string, images map[string]reference.Named) []imageValue {
+	var found []imageValue
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		// global values are copied in all sub-charts
+		if key == "global" && len(path) > 0 {
+			continue
+		}
+		keyPath := append(append([]string{}, path...), key)
+		switch value := values[key].(type) {
+		case string:
+			if !strings.Contains(strings.ToLower(key), "image") {
+				continue
+			}
+			if ref, nameOnly, ok := matchImage(value, images); ok {
+				found = append(found, imageValue{path: keyPath, value: value, ref: ref, nameOnly: nameOnly})
+			}
+		case map[string]interface{}:
+			if image, repositoryKey, hasRegistry := imageOfMap(value); len(image) > 0 {
+				if ref, _, ok := matchImage(image, images); ok {
+					found = append(found, imageValue{path: append(keyPath, repositoryKey), value: image, ref: ref, nameOnly: true, hasRegistry: hasRegistry})
+					continue
+				}
+			}
+			found = append(found, findImageValues(value, keyPath, images)...)
+		}
+	}
+	return found
+}
+
+// setValue sets a value in nested maps, creating the missing ones
+func setValue(values map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := values[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			values[key] = child
+		}
+		values = child
+	}
+	values[path[len(path)-1]] = value
+}
+
+// relocatedValues returns the values overriding the ones producing images, so that they produce their mirror images
+func (r *relocateCmd) relocatedValues(values map[string]interface{}, images map[string]reference.Named, pathMappings []registry.PathMapping) (map[string]interface{}, error) {
+	relocated := map[string]interface{}{}
+	mirrorRegistries := map[string]struct{}{}
+	for _, found := range findImageValues(values, nil, images) {
+		mirrorRef, err := registry.MirrorImageRef(found.ref, r.registryName, pathMappings)
+		if err != nil {
+			return nil, err
+		}
+		if r.verbose {
+			fmt.Fprintf(os.Stderr, "Relocating %s through %s\n", found.value, strings.Join(found.path, "."))
+		}
+		switch {
+		case found.hasRegistry:
+			registryPath := append(append([]string{}, found.path[:len(found.path)-1]...), "registry")
+			setValue(relocated, registryPath, reference.Domain(mirrorRef))
+			setValue(relocated, found.path, reference.Path(mirrorRef))
+			mirrorRegistries[reference.Domain(mirrorRef)] = struct{}{}
+		case found.nameOnly:
+			setValue(relocated, found.path, mirrorRef.Name())
+		default:
+			setValue(relocated, found.path, mirrorRef.String())
+		}
+	}
+	// bitnami-like charts give precedence to a global registry over the registry of each image
+	if global, ok := values["global"].(map[string]interface{}); ok && len(mirrorRegistries) > 0 {
+		if imageRegistry, ok := global["imageRegistry"]; ok {
+			var names []string
+			for name := range mirrorRegistries {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			switch {
+			case len(names) == 1:
+				setValue(relocated, []string{"global", "imageRegistry"}, names[0])
+			case len(valueString(imageRegistry)) > 0:
+				return nil, fmt.Errorf("images are relocated to several registries (%s), which global.imageRegistry cannot give", strings.Join(names, ", "))
+			default:
+				log.Printf("Warning: images are relocated to several registries (%s), global.imageRegistry is left empty\n", strings.Join(names, ", "))
+			}
+		}
+	}
+	return relocated, nil
+}
+
+// verify renders the chart with the relocated values, and returns the images which are not mirror images
+func (r *relocateCmd) verify(l *listCmd, valuesFile string, mirrorImages map[string]struct{}) (int, error) {
+	relocatedList := *l
+	// the chart located by the first rendering is reused, instead of being located and downloaded again
+	relocatedList.chartName = l.chartPath
+	relocatedList.chartPathOpts = action.ChartPathOptions{}
+	relocatedList.devel = false
+	relocatedList.valuesOpts.ValueFiles = append(append([]string{}, l.valuesOpts.ValueFiles...), valuesFile)
+	images, err := relocatedList.list()
+	if err != nil {
+		return 0, err
+	}
+	notRelocated := 0
+	for _, image := range excludeImages(images.get(), r.excludes) {
+		if _, ok := mirrorImages[normalizedImage(image)]; !ok {
+			for _, source := range images.getSources(image) {
+				log.Printf("Warning: image %s is not relocated in %s\n", image, source)
+			}
+			notRelocated++
+		}
+	}
+	return notRelocated, nil
+}
+
+func (r *relocateCmd) relocate(out io.Writer) error {
+	pathMappings, err := registry.ParsePathMappings(r.pathMappings)
+	if err != nil {
+		return err
+	}
+	l := &listCmd{
+		chartName:     r.chartName,
+		chartPathOpts: r.chartPathOpts,
+		devel:         r.devel,
+		namespace:     r.namespace,
+		valuesOpts:    r.valuesOpts,
+		allSubcharts:  r.allSubcharts,
+		rulesFiles:    r.rulesFiles,
+		heuristic:     r.heuristic,
+		debug:         r.debug,
+		verbose:       r.verbose,
+	}
+	images, err := l.list()
+	if err != nil {
+		return err
+	}
+	includedImages := map[string]reference.Named{}
+	mirrorImages := map[string]struct{}{}
+	for _, image := range excludeImages(images.get(), r.excludes) {
+		imageRef, err := registry.ParseImageRef(image)
+		if err != nil {
+			return err
+		}
+		mirrorRef, err := registry.MirrorImageRef(imageRef, r.registryName, pathMappings)
+		if err != nil {
+			return err
+		}
+		includedImages[imageRef.String()] = imageRef
+		mirrorImages[mirrorRef.String()] = struct{}{}
+	}
+	chart, err := loader.Load(l.chartPath)
+	if err != nil {
+		return err
+	}
+	values, err := helm.MergeValues(chart, &l.valuesOpts)
+	if err != nil {
+		return err
+	}
+	relocated, err := r.relocatedValues(values, includedImages, pathMappings)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(relocated)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp("", "helm-image-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if r.verbose {
+		fmt.Fprintf(os.Stderr, "Verifying relocated values...\n")
+	}
+	notRelocated, err := r.verify(l, f.Name(), mirrorImages)
+	if err != nil {
+		return fmt.Errorf("verifying relocated values: %w", err)
+	}
+	if notRelocated > 0 {
+		log.Printf("Warning: %d images cannot be relocated through values\n", notRelocated)
+	}
+
+	if len(r.file) > 0 {
+		err = os.WriteFile(r.file, data, 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Successfully wrote relocated values in %s\n", r.file)
+		return nil
+	}
+	_, err = out.Write(data)
+	return err
+}
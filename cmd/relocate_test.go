@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"github.com/docker/distribution/reference"
+	"github.com/gemalto/helm-image/internal/registry"
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+)
+
+const testValues = `
+global:
+  imageRegistry: ""
+image:
+  registry: docker.io
+  repository: bitnami/redis
+  tag: 7.0.11
+sidecarImage: envoy:v1.26
+proxy:
+  image:
+    repository: nginx
+  pullPolicy: IfNotPresent
+metrics:
+  image: prom/exporter:1.0
+description: nginx:1.25
+sub:
+  global:
+    imageRegistry: ""
+  initImage: busybox:1.36
+`
+
+func testImages(t *testing.T, images ...string) map[string]reference.Named {
+	refs := map[string]reference.Named{}
+	for _, image := range images {
+		ref, err := registry.ParseImageRef(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs[ref.String()] = ref
+	}
+	return refs
+}
+
+func testValuesMap(t *testing.T, content string) map[string]interface{} {
+	values := map[string]interface{}{}
+	err := yaml.Unmarshal([]byte(content), &values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestFindImageValues(t *testing.T) {
+	images := testImages(t, "docker.io/bitnami/redis:7.0.11", "envoy:v1.26", "nginx:1.25", "busybox:1.36")
+	var found []string
+	for _, value := range findImageValues(testValuesMap(t, testValues), nil, images) {
+		found = append(found, strings.Join([]string{strings.Join(value.path, "."), value.value, value.ref.String()}, " "))
+		if value.nameOnly {
+			found[len(found)-1] += " name-only"
+		}
+		if value.hasRegistry {
+			found[len(found)-1] += " registry"
+		}
+	}
+	expected := []string{
+		"image.repository docker.io/bitnami/redis:7.0.11 docker.io/bitnami/redis:7.0.11 name-only registry",
+		"proxy.image.repository nginx docker.io/library/nginx:1.25 name-only",
+		"sidecarImage envoy:v1.26 docker.io/library/envoy:v1.26",
+		"sub.initImage busybox:1.36 docker.io/library/busybox:1.36",
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v, got %v", expected, found)
+	}
+}
+
+func TestRelocatedValues(t *testing.T) {
+	images := testImages(t, "docker.io/bitnami/redis:7.0.11", "envoy:v1.26", "nginx:1.25", "busybox:1.36")
+	tests := []struct {
+		name         string
+		values       string
+		registryName string
+		pathMappings []string
+		expected     string
+	}{
+		{
+			name:         "global registry",
+			values:       testValues,
+			registryName: "mirror.local:5000",
+			expected: `global:
+  imageRegistry: mirror.local:5000
+image:
+  registry: mirror.local:5000
+  repository: bitnami/redis
+proxy:
+  image:
+    repository: mirror.local:5000/library/nginx
+sidecarImage: mirror.local:5000/library/envoy:v1.26
+sub:
+  initImage: mirror.local:5000/library/busybox:1.36
+`,
+		},
+		{
+			name:         "path prefix and mappings",
+			values:       testValues,
+			registryName: "mirror.local:5000/charts",
+			pathMappings: []string{"docker.io/library=base"},
+			expected: `global:
+  imageRegistry: mirror.local:5000
+image:
+  registry: mirror.local:5000
+  repository: charts/bitnami/redis
+proxy:
+  image:
+    repository: mirror.local:5000/charts/base/nginx
+sidecarImage: mirror.local:5000/charts/base/envoy:v1.26
+sub:
+  initImage: mirror.local:5000/charts/base/busybox:1.36
+`,
+		},
+		{
+			name: "without global registry",
+			values: `image:
+  registry: docker.io
+  repository: bitnami/redis
+  tag: 7.0.11
+`,
+			registryName: "mirror.local:5000",
+			expected: `image:
+  registry: mirror.local:5000
+  repository: bitnami/redis
+`,
+		},
+		{
+			name: "global registry without registry values",
+			values: `global:
+  imageRegistry: ""
+image: nginx:1.25
+`,
+			registryName: "mirror.local:5000",
+			expected: `image: mirror.local:5000/library/nginx:1.25
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pathMappings, err := registry.ParsePathMappings(test.pathMappings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &relocateCmd{
+				registryName: test.registryName,
+			}
+			relocated, err := r.relocatedValues(testValuesMap(t, test.values), images, pathMappings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := yaml.Marshal(relocated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, data)
+			}
+		})
+	}
+}
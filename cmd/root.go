@@ -15,6 +15,13 @@ func NewRootCmd(out io.Writer, args []string) *cobra.Command {
 		newListCmd(out),
 		newSaveCmd(out),
 		newPullCmd(out),
+		newLockCmd(out),
+		newDiffCmd(out),
+		newCheckCmd(out),
+		newMirrorCmd(out),
+		newLoadCmd(out),
+		newPostRenderCmd(out),
+		newRelocateCmd(out),
 		newCacheCmd(out),
 	)
 	return cmd
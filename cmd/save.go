@@ -5,29 +5,39 @@ import (
 	"fmt"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/gemalto/helm-image/internal/containerd"
-	"github.com/gemalto/helm-image/internal/credentials"
 	"github.com/gemalto/helm-image/internal/registry"
 	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	cliValues "helm.sh/helm/v3/pkg/cli/values"
 	"io"
-	"log"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
 )
 
 type saveCmd struct {
-	chartName  string
-	outputFile string
-	namespace  string
-	excludes   []string
-	auths      []string
-	valuesOpts cliValues.Options
-	helmPath   string
-	verbose    bool
-	debug      bool
+	chartName           string
+	chartPathOpts       action.ChartPathOptions
+	devel               bool
+	outputFile          string
+	namespace           string
+	excludes            []string
+	auths               []string
+	valuesOpts          cliValues.Options
+	allSubcharts        bool
+	profiles            []string
+	profilesFile        string
+	rulesFiles          []string
+	heuristic           bool
+	lockFile            string
+	platforms           []string
+	allPlatforms        bool
+	concurrency         int
+	backend             string
+	containerdAddress   string
+	containerdNamespace string
+	verbose             bool
+	debug               bool
 }
 
 func newSaveCmd(out io.Writer) *cobra.Command {
@@ -49,16 +59,30 @@ func newSaveCmd(out io.Writer) *cobra.Command {
 
 	flags.StringSliceVarP(&s.auths, "auth", "a", []string{}, "specify private registries which need authentication during pull")
 	flags.StringSliceVarP(&s.excludes, "exclude", "x", []string{}, "specify docker images to be excluded from pulls")
+	flags.StringVar(&s.chartPathOpts.Version, "version", "", "specify a version constraint for the chart version to use, latest version being used if not set")
+	flags.StringVar(&s.chartPathOpts.RepoURL, "repo", "", "chart repository url where to locate the requested chart")
+	flags.StringVar(&s.chartPathOpts.Username, "username", "", "chart repository username where to locate the requested chart")
+	flags.StringVar(&s.chartPathOpts.Password, "password", "", "chart repository password where to locate the requested chart")
+	flags.BoolVar(&s.devel, "devel", false, "use development versions, too (equivalent to version '>0.0.0-0'), ignored if --version is set")
 	flags.StringSliceVarP(&s.valuesOpts.ValueFiles, "values", "f", []string{}, "specify values in a YAML file or a URL (can specify multiple)")
 	flags.StringArrayVar(&s.valuesOpts.Values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
 	flags.StringArrayVar(&s.valuesOpts.StringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
 	flags.StringArrayVar(&s.valuesOpts.FileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	flags.BoolVar(&s.allSubcharts, "all-subcharts", false, "search all sub-charts, forcing their conditions and tags to true by groups of same weight")
+	flags.StringSliceVar(&s.profiles, "profile", []string{}, "render the chart for a named profile of values files, as name=file (can specify multiple)")
+	flags.StringVar(&s.profilesFile, "profiles-file", "", "render the chart for each profile of values files defined in a YAML file")
+	flags.StringSliceVar(&s.rulesFiles, "rules", []string{}, "specify image extraction rules for custom resources in a YAML file (can specify multiple)")
+	flags.BoolVar(&s.heuristic, "heuristic", true, "search for containers at any depth of resources which are neither known nor matched by a rule")
+	flags.StringVar(&s.lockFile, "lock", "", "fetch the digests the images are pinned to in the given lock file (see lock command)")
+	flags.StringSliceVar(&s.platforms, "platform", []string{}, "save images for the given platforms, e.g. linux/amd64,linux/arm64 (can specify multiple), linux platform of the host being used if not set")
+	flags.BoolVar(&s.allPlatforms, "all-platforms", false, "save images for all their platforms")
+	flags.IntVar(&s.concurrency, "concurrency", 1, "number of images pulled at once")
+	flags.StringVar(&s.backend, "backend", backendLocal, "backend pulling and saving images, one of local (in-process) or embedded (containerd server shipped with the plugin)")
+	flags.StringVar(&s.containerdAddress, "containerd-address", os.Getenv("CONTAINERD_ADDRESS"), "address of an existing containerd server where to pull images, e.g. /run/containerd/containerd.sock, no server being started if set")
+	flags.StringVar(&s.containerdNamespace, "containerd-namespace", defaultContainerdNamespace(), "containerd namespace where to pull images, e.g. k8s.io for kubernetes or moby for docker")
 	flags.BoolVarP(&s.verbose, "verbose", "v", false, "enable verbose output")
 	flags.StringVarP(&s.outputFile, "output", "o", "", "image file name")
 
-	// When called through helm, helm path is transmitted through the HELM_BIN envvar
-	s.helmPath = os.Getenv("HELM_BIN")
-
 	// When called through helm, debug mode is transmitted through the HELM_DEBUG envvar
 	helmDebug := os.Getenv("HELM_DEBUG")
 	if helmDebug == "1" || strings.EqualFold(helmDebug, "true") || strings.EqualFold(helmDebug, "on") {
@@ -77,97 +101,62 @@ func newSaveCmd(out io.Writer) *cobra.Command {
 }
 
 func (s *saveCmd) save() error {
-	l := &listCmd{
-		chartName:  s.chartName,
-		namespace:  s.namespace,
-		valuesOpts: s.valuesOpts,
-		helmPath:   s.helmPath,
-		debug:      s.debug,
-		verbose:    s.verbose,
-	}
-	images, err := l.list()
-	includedImagesMap := map[string]struct{}{}
-	for _, image := range images {
-		includedImagesMap[image] = struct{}{}
-		for _, excludedImage := range s.excludes {
-			if image == excludedImage {
-				delete(includedImagesMap, image)
-			}
-		}
+	err := checkBackend(s.backend)
+	if err != nil {
+		return err
 	}
-	var includedImages []string
-	for image := range includedImagesMap {
-		includedImages = append(includedImages, image)
+	if s.concurrency < 1 {
+		return fmt.Errorf("--concurrency shall be at least 1")
 	}
+	profiles, err := parseProfiles(s.profiles, s.profilesFile)
 	if err != nil {
 		return err
 	}
-	// TODO manage remote charts
-	chart, err := loader.Load(l.chartName)
+	platforms, err := containerd.ParsePlatforms(s.platforms, s.allPlatforms)
 	if err != nil {
 		return err
 	}
-	serverStarted := make(chan bool)
-	serverKill := make(chan bool)
-	serverKilled := make(chan bool)
-	go containerd.Server(serverStarted, serverKill, serverKilled, l.debug)
-	if !<-serverStarted {
-		return fmt.Errorf("cannot start containerd server")
+	l := &listCmd{
+		chartName:     s.chartName,
+		chartPathOpts: s.chartPathOpts,
+		devel:         s.devel,
+		namespace:     s.namespace,
+		valuesOpts:    s.valuesOpts,
+		allSubcharts:  s.allSubcharts,
+		profiles:      profiles,
+		rulesFiles:    s.rulesFiles,
+		heuristic:     s.heuristic,
+		debug:         s.debug,
+		verbose:       s.verbose,
 	}
-	interrupt := make(chan os.Signal)
-	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-interrupt
-		if l.debug {
-			log.Println("Sending interrupt signal to containerd server...")
-		}
-		serverKill <- true
-		<-serverKilled
-	}()
-	client, err := containerd.Client(l.debug)
+	images, err := l.list()
 	if err != nil {
-		if l.debug {
-			log.Println("Sending interrupt signal to containerd server...")
-		}
-		serverKill <- true
-		<-serverKilled
 		return err
 	}
-	ctx := namespaces.WithNamespace(context.Background(), "default")
-	for _, auth := range s.auths {
-		login, password, err := credentials.GetAuth(auth)
-		if err != nil && l.debug {
-			log.Printf("Warning: cannot get authentication information: %s\n", err)
-		}
-		registry.AddAuthRegistry(auth, login, password)
-	}
-	for _, image := range includedImages {
-		err = containerd.PullImage(ctx, client, registry.ConsoleCredentials, image, l.debug)
+	includedImages := excludeImages(images.get(), s.excludes)
+	if len(s.lockFile) > 0 {
+		includedImages, err = pinImages(includedImages, s.lockFile)
 		if err != nil {
-			if l.debug {
-				log.Println("Sending interrupt signal to containerd server...")
-			}
-			serverKill <- true
-			<-serverKilled
 			return err
 		}
 	}
-	if len(s.outputFile) == 0 {
-		s.outputFile = chart.Name() + ".tar"
+	chart, err := loader.Load(l.chartPath)
+	if err != nil {
+		return err
 	}
-	err = containerd.SaveImages(ctx, client, includedImages, s.outputFile)
+	client, closeBackend, err := openBackend(s.backend, s.containerdAddress, l.debug)
 	if err != nil {
-		if l.debug {
-			log.Println("Sending interrupt signal to containerd server...")
-		}
-		serverKill <- true
-		<-serverKilled
 		return err
 	}
-	if l.debug {
-		log.Println("Sending interrupt signal to containerd server...")
+	defer closeBackend()
+	ctx := namespaces.WithNamespace(context.Background(), s.containerdNamespace)
+	addAuthRegistries(s.auths, l.debug)
+	err = containerd.PullImages(ctx, client, registry.ConsoleCredentials, includedImages, platforms, s.concurrency, l.debug)
+	if err != nil {
+		return err
+	}
+	if len(s.outputFile) == 0 {
+		s.outputFile = chart.Name() + ".tar"
 	}
-	serverKill <- true
-	<-serverKilled
-	return nil
+	return containerd.SaveImages(ctx, client, includedImages, s.outputFile, platforms)
 }
@@ -0,0 +1,179 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/images/archive"
+	"github.com/gemalto/helm-image/internal/registry"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"log"
+	"os"
+	"sort"
+)
+
+// Archive gives access to the images of an archive written by SaveImages, imported in a temporary content store
+type Archive struct {
+	dir    string
+	store  content.Store
+	images map[string]ocispec.Descriptor
+}
+
+// OpenArchive imports an archive in a temporary content store, to be removed with Close
+func OpenArchive(ctx context.Context, fileName string) (*Archive, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dir, err := os.MkdirTemp("", "helm-image-")
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{
+		dir:    dir,
+		images: map[string]ocispec.Descriptor{},
+	}
+	a.store, err = local.NewStore(dir)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	indexDesc, err := archive.ImportIndex(ctx, a.store, f)
+	if err != nil {
+		a.Close()
+		return nil, fmt.Errorf("importing %s: %w", fileName, err)
+	}
+	data, err := content.ReadBlob(ctx, a.store, indexDesc)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	var index ocispec.Index
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		a.Close()
+		return nil, fmt.Errorf("parsing index of %s: %w", fileName, err)
+	}
+	for _, desc := range index.Manifests {
+		name := desc.Annotations[images.AnnotationImageName]
+		if len(name) == 0 {
+			log.Printf("Warning: ignoring image %s of %s without name\n", desc.Digest, fileName)
+			continue
+		}
+		a.images[name] = desc
+	}
+	return a, nil
+}
+
+// Images returns the names of the images of the archive
+func (a *Archive) Images() []string {
+	var names []string
+	for name := range a.images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close removes the temporary content store
+func (a *Archive) Close() error {
+	return os.RemoveAll(a.dir)
+}
+
+// available returns the descriptor of an image restricted to the platforms saved in the archive, as registries
+// reject indexes referencing unknown manifests
+func (a *Archive) available(ctx context.Context, name string, desc ocispec.Descriptor) (ocispec.Descriptor, error) {
+	if !images.IsIndexType(desc.MediaType) {
+		return desc, nil
+	}
+	data, err := content.ReadBlob(ctx, a.store, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	var index ocispec.Index
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("parsing index of %s: %w", name, err)
+	}
+	var manifests []ocispec.Descriptor
+	for _, manifest := range index.Manifests {
+		if _, err := a.store.Info(ctx, manifest.Digest); err == nil {
+			manifests = append(manifests, manifest)
+		}
+	}
+	switch len(manifests) {
+	case len(index.Manifests):
+		return desc, nil
+	case 0:
+		return ocispec.Descriptor{}, fmt.Errorf("no manifest of %s found in archive", name)
+	case 1:
+		log.Printf("Warning: only one platform of %s found in archive, pushing its manifest instead of the index\n", name)
+		return manifests[0], nil
+	}
+	log.Printf("Warning: only %d of %d platforms of %s found in archive, pushing a new index\n", len(manifests), len(index.Manifests), name)
+	index.Manifests = manifests
+	data, err = json.Marshal(index)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	availableDesc := ocispec.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	err = content.WriteBlob(ctx, a.store, availableDesc.Digest.String(), bytes.NewReader(data), availableDesc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return availableDesc, nil
+}
+
+// storeFetcher fetches content from a content store instead of a registry
+type storeFetcher struct {
+	store content.Store
+}
+
+func (f *storeFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	readerAt, err := f.store.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{content.NewReader(readerAt), readerAt}, nil
+}
+
+// PushArchiveImage pushes an image of an archive, with all its saved platforms, to the target reference
+func (m *Mirror) PushArchiveImage(ctx context.Context, a *Archive, name string, targetName string) error {
+	fmt.Printf("Pushing image %s to %s...\n", name, targetName)
+	desc, ok := a.images[name]
+	if !ok {
+		return fmt.Errorf("image %s not found in archive", name)
+	}
+	targetRef, err := registry.ParseImageRef(targetName)
+	if err != nil {
+		return err
+	}
+	desc, err = a.available(ctx, name, desc)
+	if err != nil {
+		return err
+	}
+	pusher, err := NewResolver(m.credentials, m.plainHTTP).Pusher(ctx, pushReference(targetRef, desc.Digest))
+	if err != nil {
+		return err
+	}
+	err = m.copy(ctx, &storeFetcher{a.store}, pusher, desc, targetRef)
+	if err != nil {
+		return fmt.Errorf("pushing %s: %w", name, err)
+	}
+	fmt.Printf("Successfully pushed %s image\n", targetName)
+	return nil
+}
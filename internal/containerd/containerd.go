@@ -3,6 +3,7 @@ package containerd
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/content"
@@ -12,7 +13,6 @@ import (
 	"github.com/containerd/containerd/platforms"
 	"github.com/containerd/containerd/remotes"
 	"github.com/containerd/containerd/remotes/docker"
-	"github.com/docker/distribution/reference"
 	"github.com/gemalto/helm-image/internal/registry"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -23,72 +23,14 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 )
 
-var containerdConfig = `
-version = 2
-plugin_dir = ""
-disabled_plugins = [ "io.containerd.grpc.v1.cri" ]
-required_plugins = []
-oom_score = 0
-
-[grpc]
-  address = "\\\\.\\pipe\\containerd-containerd"
-  tcp_address = ""
-  tcp_tls_cert = ""
-  tcp_tls_key = ""
-  uid = 0
-  gid = 0
-  max_recv_message_size = 16777216
-  max_send_message_size = 16777216
-
-[ttrpc]
-  address = ""
-  uid = 0
-  gid = 0
-
-[debug]
-  address = ""
-  uid = 0
-  gid = 0
-  level = ""
-
-[metrics]
-  address = ""
-  grpc_histogram = false
-
-[cgroup]
-  path = ""
-
-[timeouts]
-  "io.containerd.timeout.shim.cleanup" = "5s"
-  "io.containerd.timeout.shim.load" = "5s"
-  "io.containerd.timeout.shim.shutdown" = "3s"
-  "io.containerd.timeout.task.state" = "2s"
-
-[plugins]
-  [plugins."io.containerd.gc.v1.scheduler"]
-    pause_threshold = 0.02
-    deletion_threshold = 0
-    mutation_threshold = 100
-    schedule_delay = "0s"
-    startup_delay = "100ms"
-  [plugins."io.containerd.internal.v1.opt"]
-    path = "C:\\Users\\cvila\\.containerd\\root\\opt"
-  [plugins."io.containerd.internal.v1.restart"]
-    interval = "10s"
-  [plugins."io.containerd.metadata.v1.bolt"]
-    content_sharing_policy = "shared"
-  [plugins."io.containerd.runtime.v2.task"]
-    platforms = ["windows/amd64", "linux/amd64"]
-  [plugins."io.containerd.service.v1.diff-service"]
-    default = ["windows", "windows-lcow"]
-`
-
 var fileLog *log.Logger
 
+// maxConnsPerRegistry limits the connections opened to each registry by concurrent pulls
+const maxConnsPerRegistry = 6
+
 type jobs struct {
 	name     string
 	added    map[digest.Digest]struct{}
@@ -229,7 +171,9 @@ func newImageParts(statusChanged func(string, imagePart), offsetChanged func(str
 //	}
 //}
 
-func displayPart(name string, part imagePart) {
+// displayPart prints the progress of a part of an image, prefixed with the image name so that the progress of
+// concurrent pulls remains readable
+func displayPart(imageName string, name string, part imagePart) {
 	nameParts := strings.Split(name, ":")
 	var displayName string
 	if len(nameParts) == 2 && len(nameParts[1]) == 64 {
@@ -238,15 +182,15 @@ func displayPart(name string, part imagePart) {
 		displayName = name
 	}
 	if part.status == "downloading" {
-		fmt.Printf("%s: Pulling fs layer\n", displayName)
+		fmt.Printf("[%s] %s: Pulling fs layer\n", imageName, displayName)
 	} else if part.status == "done" {
-		fmt.Printf("%s: Download complete\n", displayName)
+		fmt.Printf("[%s] %s: Download complete\n", imageName, displayName)
 	} else if part.status == "waiting" {
-		fmt.Printf("%s: Waiting\n", displayName)
+		fmt.Printf("[%s] %s: Waiting\n", imageName, displayName)
 	}
 }
 
-func manageActive(ctx context.Context, cs content.Store, parts *imageParts) map[string]struct{} {
+func manageActive(ctx context.Context, cs content.Store, ongoing *jobs, parts *imageParts) map[string]struct{} {
 	fileLog.Println("---> manageActive")
 	activeSeen := map[string]struct{}{}
 	active, err := cs.ListStatuses(ctx, "")
@@ -254,8 +198,16 @@ func manageActive(ctx context.Context, cs content.Store, parts *imageParts) map[
 		log.Printf("Warning: failed to get content statuses: %s\n", err)
 		return activeSeen
 	}
+	// entries of the other images being pulled at the same time are ignored
+	keys := map[string]struct{}{}
+	for _, j := range ongoing.jobs() {
+		keys[remotes.MakeRefKey(ctx, j)] = struct{}{}
+	}
 	// update status of active entries
 	for _, active := range active {
+		if _, ok := keys[active.Ref]; !ok {
+			continue
+		}
 		parts.set(active.Ref, "downloading", imagePart{
 			offset:    active.Offset,
 			total:     active.Total,
@@ -330,9 +282,11 @@ func showProgress(ctx context.Context, ongoing *jobs, cs content.Store) {
 		start  = time.Now()
 		//bars       = newImageBars(barManager)
 		//parts      = newImageParts(bars.update, bars.update)
-		parts = newImageParts(displayPart, nil)
-		last  bool
-		stop  bool
+		parts = newImageParts(func(name string, part imagePart) {
+			displayPart(ongoing.name, name, part)
+		}, nil)
+		last bool
+		stop bool
 	)
 	defer ticker.Stop()
 
@@ -352,7 +306,7 @@ outer:
 			parts.set(ongoing.name, resolved, imagePart{})
 			activeSeen := map[string]struct{}{}
 			if !stop {
-				activeSeen = manageActive(ctx, cs, parts)
+				activeSeen = manageActive(ctx, cs, ongoing, parts)
 			}
 			err := manageInactive(ctx, cs, start, ongoing, activeSeen, parts)
 			if err != nil {
@@ -378,48 +332,195 @@ func closeClient(client *containerd.Client) {
 	}
 }
 
-func imageRef(imageName string) (reference.Named, error) {
-	imageRef, err := reference.ParseNormalizedNamed(imageName)
-	if err != nil {
-		return nil, err
-	}
-	if reference.IsNameOnly(imageRef) {
-		imageRef = reference.TagNameOnly(imageRef)
-	}
-	return imageRef, nil
+// NewResolver returns a resolver for docker registries, authenticating with the given credentials,
+// and reaching registries through plain HTTP if requested
+func NewResolver(credentials registry.Credentials, plainHTTP bool) remotes.Resolver {
+	return newResolver(credentials, plainHTTP, http.DefaultClient)
 }
 
-func PullImage(ctx context.Context, client *containerd.Client, credentials registry.Credentials, imageName string, verbose bool) error {
-	fmt.Printf("Pulling image %s...\n", imageName)
-
-	imageRef, err := imageRef(imageName)
-	if err != nil {
-		return err
+// newRegistryClient returns an HTTP client opening at most maxConnsPerRegistry connections to each registry,
+// requests beyond this limit waiting for a connection to be available
+func newRegistryClient() *http.Client {
+	transport, ok := http.DefaultClient.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+	transport.MaxConnsPerHost = maxConnsPerRegistry
+	return &http.Client{
+		Transport: transport,
 	}
+}
 
-	resolver := docker.NewResolver(docker.ResolverOptions{
+func newResolver(credentials registry.Credentials, plainHTTP bool, client *http.Client) remotes.Resolver {
+	return docker.NewResolver(docker.ResolverOptions{
 		Tracker: docker.NewInMemoryTracker(),
 		Hosts: func(host string) ([]docker.RegistryHost, error) {
 			dockerHeaders := make(http.Header)
 			dockerHeaders.Set("User-Agent", "containerd/1.6.2")
 			dockerAuthorizer := docker.NewDockerAuthorizer(
-				docker.WithAuthClient(http.DefaultClient),
+				docker.WithAuthClient(client),
 				docker.WithAuthHeader(dockerHeaders),
 				docker.WithAuthCreds(credentials(host)))
 			if host == "docker.io" {
 				host = "registry-1.docker.io"
 			}
+			scheme := "https"
+			if plainHTTP {
+				scheme = "http"
+			}
 			config := docker.RegistryHost{
-				Client:       http.DefaultClient,
+				Client:       client,
 				Authorizer:   dockerAuthorizer,
 				Host:         host,
-				Scheme:       "https",
+				Scheme:       scheme,
 				Path:         "/v2",
 				Capabilities: docker.HostCapabilityPull | docker.HostCapabilityResolve | docker.HostCapabilityPush,
 			}
 			return []docker.RegistryHost{config}, nil
 		},
 	})
+}
+
+// ResolvedImage describes the manifest an image tag points to
+type ResolvedImage struct {
+	Name      string
+	Digest    digest.Digest
+	MediaType string
+	Size      int64
+	Index     bool
+}
+
+// PlatformImage describes the manifest of one platform of a multi-arch image
+type PlatformImage struct {
+	Platform string
+	Digest   digest.Digest
+}
+
+// ResolveImage queries the registry of an image for the digest and media type of its manifest
+func ResolveImage(ctx context.Context, resolver remotes.Resolver, imageName string) (*ResolvedImage, error) {
+	imageRef, err := registry.ParseImageRef(imageName)
+	if err != nil {
+		return nil, err
+	}
+	name, desc, err := resolver.Resolve(ctx, imageRef.String())
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", imageName, err)
+	}
+	return &ResolvedImage{
+		Name:      name,
+		Digest:    desc.Digest,
+		MediaType: desc.MediaType,
+		Size:      desc.Size,
+		Index:     images.IsIndexType(desc.MediaType),
+	}, nil
+}
+
+// ResolvePlatforms fetches the index of a multi-arch image and returns the manifest of each of its platforms
+func ResolvePlatforms(ctx context.Context, resolver remotes.Resolver, resolved *ResolvedImage) ([]PlatformImage, error) {
+	if !resolved.Index {
+		return nil, nil
+	}
+	fetcher, err := resolver.Fetcher(ctx, resolved.Name)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := fetcher.Fetch(ctx, ocispec.Descriptor{
+		MediaType: resolved.MediaType,
+		Digest:    resolved.Digest,
+		Size:      resolved.Size,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching index of %s: %w", resolved.Name, err)
+	}
+	defer reader.Close()
+	var index ocispec.Index
+	err = json.NewDecoder(reader).Decode(&index)
+	if err != nil {
+		return nil, fmt.Errorf("parsing index of %s: %w", resolved.Name, err)
+	}
+	var platformImages []PlatformImage
+	for _, manifest := range index.Manifests {
+		if manifest.Platform == nil {
+			continue
+		}
+		platformImages = append(platformImages, PlatformImage{
+			Platform: platforms.Format(*manifest.Platform),
+			Digest:   manifest.Digest,
+		})
+	}
+	return platformImages, nil
+}
+
+func PullImage(ctx context.Context, client *containerd.Client, credentials registry.Credentials, imageName string, selected *Platforms, verbose bool) error {
+	return pullImage(ctx, client, NewResolver(credentials, false), imageName, selected, verbose)
+}
+
+// PullImages pulls images with at most concurrency pulls at once, and at most maxConnsPerRegistry connections to
+// each registry. Layers shared by several images are only fetched once, the content store letting a single pull
+// write a blob while the others wait for it. Once a pull has failed, the other ones are cancelled
+func PullImages(ctx context.Context, client *containerd.Client, credentials registry.Credentials, imageNames []string, selected *Platforms, concurrency int, verbose bool) error {
+	if concurrency < 1 {
+		return fmt.Errorf("invalid concurrency %d, shall be at least 1", concurrency)
+	}
+	resolver := newResolver(credentials, false, newRegistryClient())
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   []error
+		images = make(chan string)
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for imageName := range images {
+				err := pullImage(ctx, client, resolver, imageName, selected, verbose)
+				if err != nil {
+					mu.Lock()
+					// pulls cancelled because of another failure are not reported
+					if len(errs) == 0 || ctx.Err() == nil {
+						errs = append(errs, fmt.Errorf("pulling %s: %w", imageName, err))
+					}
+					mu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+dispatch:
+	for _, imageName := range imageNames {
+		select {
+		case images <- imageName:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(images)
+	wg.Wait()
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	if len(errs) > 1 {
+		for _, err := range errs {
+			log.Printf("Error: %s\n", err)
+		}
+		return fmt.Errorf("failed to pull %d images", len(errs))
+	}
+	return ctx.Err()
+}
+
+// pullImage fetches the manifests of all the selected platforms of an image along with their layers, client.Pull
+// only fetching the best matching manifest of an index
+func pullImage(ctx context.Context, client *containerd.Client, resolver remotes.Resolver, imageName string, selected *Platforms, verbose bool) error {
+	fmt.Printf("Pulling image %s for %s...\n", imageName, selected)
+
+	imageRef, err := registry.ParseImageRef(imageName)
+	if err != nil {
+		return err
+	}
 
 	if verbose {
 		ongoing := newJobs(imageName)
@@ -437,8 +538,8 @@ func PullImage(ctx context.Context, client *containerd.Client, credentials regis
 			return nil, nil
 		})
 
-		image, err := client.Pull(ctx, imageRef.String(), []containerd.RemoteOpt{
-			containerd.WithPlatform("linux"),
+		image, err := client.Fetch(ctx, imageRef.String(), []containerd.RemoteOpt{
+			containerd.WithPlatformMatcher(selected.Matcher()),
 			containerd.WithResolver(resolver),
 			containerd.WithImageHandler(handler),
 			containerd.WithSchema1Conversion,
@@ -448,47 +549,71 @@ func PullImage(ctx context.Context, client *containerd.Client, credentials regis
 		if err != nil {
 			return err
 		}
-		fmt.Printf("Successfully pulled %s image\n", image.Name())
+		fmt.Printf("Successfully pulled %s image\n", image.Name)
 	} else {
-		image, err := client.Pull(ctx, imageRef.String(), []containerd.RemoteOpt{
-			containerd.WithPlatform("linux"),
+		image, err := client.Fetch(ctx, imageRef.String(), []containerd.RemoteOpt{
+			containerd.WithPlatformMatcher(selected.Matcher()),
 			containerd.WithResolver(resolver),
 			containerd.WithSchema1Conversion,
 		}...)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("Successfully pulled %s image\n", image.Name())
+		fmt.Printf("Successfully pulled %s image\n", image.Name)
 	}
 
 	return nil
 }
 
-func SaveImages(ctx context.Context, client *containerd.Client, images []string, fileName string) error {
+// SaveImages exports images in a file, with the manifests of the selected platforms only, indexes of multi-arch
+// images being restricted to these manifests
+func SaveImages(ctx context.Context, client *containerd.Client, images []string, fileName string, selected *Platforms) error {
 	if len(images) == 0 {
 		return fmt.Errorf("no images to save")
 	}
-	fmt.Printf("Saving images in %s...\n", fileName)
-	var exportOpts []archive.ExportOpt
-	p, err := platforms.Parse("linux")
-	exportOpts = append(exportOpts, archive.WithPlatform(platforms.Ordered(p)))
+	fmt.Printf("Saving images for %s in %s...\n", selected, fileName)
+	ctx, done, err := client.WithLease(ctx)
 	if err != nil {
 		return err
 	}
+	defer done(ctx)
+	var exportOpts []archive.ExportOpt
+	if selected.All() {
+		exportOpts = append(exportOpts, archive.WithAllPlatforms())
+	} else {
+		exportOpts = append(exportOpts, archive.WithPlatform(selected.Matcher()))
+	}
 	is := client.ImageService()
 	for _, img := range images {
-		imageRef, err := imageRef(img)
+		imageRef, err := registry.ParseImageRef(img)
+		if err != nil {
+			return err
+		}
+		image, err := is.Get(ctx, imageRef.String())
+		if err != nil {
+			return err
+		}
+		desc, err := selected.selectManifests(ctx, client.ContentStore(), img, image.Target)
 		if err != nil {
 			return err
 		}
-		exportOpts = append(exportOpts, archive.WithImage(is, imageRef.String()))
+		err = selected.checkManifests(ctx, client.ContentStore(), img, desc)
+		if err != nil {
+			return err
+		}
+		exportOpts = append(exportOpts, archive.WithManifest(desc, imageRef.String()))
 	}
 	f, err := os.Create(fileName)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	err = client.Export(ctx, bufio.NewWriter(f), exportOpts...)
+	w := bufio.NewWriter(f)
+	err = client.Export(ctx, w, exportOpts...)
+	if err != nil {
+		return err
+	}
+	err = w.Flush()
 	if err != nil {
 		return err
 	}
@@ -507,22 +632,40 @@ func ListImages(ctx context.Context, client *containerd.Client) error {
 	return nil
 }
 
-func ClientWithAddress(address string, debug bool) (*containerd.Client, error) {
-	homeDir, err := os.UserHomeDir()
+// serverDirs gathers the directories of the embedded containerd server
+type serverDirs struct {
+	config string
+	log    string
+	root   string
+	state  string
+}
+
+// tomlString quotes a string as a TOML basic string
+func tomlString(s string) string {
+	return "\"" + strings.ReplaceAll(strings.ReplaceAll(s, "\\", "\\\\"), "\"", "\\\"") + "\""
+}
+
+// ClientWithAddress returns a client connected to the containerd server listening on the given address, and the
+// function closing it. The client log file is kept open until then, as it is written while pulling images
+func ClientWithAddress(address string, debug bool) (*containerd.Client, func(), error) {
+	dirs, err := containerdDirs()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	clientLogFile, err := os.OpenFile(filepath.Join(homeDir, ".containerd", "client.log"), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	// the log directory does not exist yet when connecting to an existing containerd server
+	err = os.MkdirAll(dirs.log, 0700)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	clientLogFile, err := os.OpenFile(filepath.Join(dirs.log, "client.log"), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
 	}
-	defer clientLogFile.Close()
 	fileLog = log.New(clientLogFile, "container", log.LstdFlags)
 	if debug {
 		log.Println("Creating containerd client...")
 	}
 	var client *containerd.Client
-	defer closeClient(client)
 	for i := 0; i < 12; i++ {
 		client, err = containerd.New(address, containerd.WithTimeout(1*time.Second))
 		if client != nil {
@@ -532,49 +675,74 @@ func ClientWithAddress(address string, debug bool) (*containerd.Client, error) {
 		}
 	}
 	if client == nil {
-		return nil, fmt.Errorf("containerd server unavailable: %w", err)
+		clientLogFile.Close()
+		return nil, nil, fmt.Errorf("containerd server unavailable: %w", err)
 	}
-	return client, nil
+	return client, func() {
+		closeClient(client)
+		clientLogFile.Close()
+	}, nil
 }
 
-func Client(debug bool) (*containerd.Client, error) {
-	return ClientWithAddress("\\\\.\\pipe\\containerd-containerd", debug)
+// Client returns a client connected to the embedded containerd server, and the function closing it
+func Client(debug bool) (*containerd.Client, func(), error) {
+	dirs, err := containerdDirs()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ClientWithAddress(serverAddress(dirs), debug)
+}
+
+// serverBinary returns the containerd binary shipped with the plugin, or found in the PATH
+func serverBinary() (string, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	binary := filepath.Join(filepath.Dir(execPath), serverBinaryName)
+	if _, err := os.Stat(binary); err == nil {
+		return binary, nil
+	}
+	return exec.LookPath(serverBinaryName)
 }
 
 func Server(serverStarted chan bool, serverKill chan bool, serverKilled chan bool, debug bool) {
 	err := CreateContainerdDirectories()
 	if err != nil {
 		log.Printf("Error: cannot create containerd directories: %s\n", err)
+		serverStarted <- false
+		return
 	}
-	homeDir, err := os.UserHomeDir()
+	dirs, err := containerdDirs()
 	if err != nil {
 		log.Printf("Error: cannot create containerd.log file: %s\n", err)
 		serverStarted <- false
+		return
 	}
-	serverLogFile, err := os.OpenFile(filepath.Join(homeDir, ".containerd", "containerd.log"), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	serverLogFile, err := os.OpenFile(filepath.Join(dirs.log, "containerd.log"), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Printf("Error: cannot create containerd.log file: %s\n", err)
 		serverStarted <- false
+		return
 	}
 	defer serverLogFile.Close()
 	if debug {
 		log.Println("Running containerd server...")
 	}
-	execPath, err := os.Executable()
+	binary, err := serverBinary()
 	if err != nil {
-		log.Printf("Error: cannot start containerd: %s\n", err)
+		log.Printf("Error: cannot find containerd: %s\n", err)
+		serverStarted <- false
+		return
 	}
-	cmd := exec.Command(filepath.Join(filepath.Dir(execPath), "containerd"), "--config", filepath.Join(homeDir, ".containerd", "config.toml"))
+	cmd := exec.Command(binary, "--config", filepath.Join(dirs.config, "config.toml"))
 	cmd.Stdout = serverLogFile
 	cmd.Stderr = serverLogFile
 	err = cmd.Start()
 	if err != nil {
 		log.Printf("Error: Cannot start containerd: %s\n", err)
 		serverStarted <- false
-	}
-	if cmd.Process == nil {
-		log.Println("Error: Cannot start containerd")
-		serverStarted <- false
+		return
 	}
 	// Wait a bit to make sure client can connect to server
 	time.Sleep(3 * time.Second)
@@ -586,62 +754,36 @@ func Server(serverStarted chan bool, serverKill chan bool, serverKilled chan boo
 	if debug {
 		log.Println("Stopping containerd server...")
 	}
-	_ = cmd.Process.Signal(syscall.SIGKILL)
+	_ = cmd.Process.Signal(serverStopSignal)
 	// Wait a bit to make sure signal is processed before client process is gone
 	time.Sleep(3 * time.Second)
 	serverKilled <- true
 }
 
 func DeleteContainerdDirectories() error {
-	homeDir, err := os.UserHomeDir()
+	dirs, err := containerdDirs()
 	if err != nil {
 		return err
 	}
-	baseDir := filepath.Join(homeDir, ".containerd")
-	err = os.RemoveAll(baseDir)
-	return err
+	for _, dir := range []string{dirs.root, dirs.state, dirs.config, dirs.log} {
+		err = os.RemoveAll(dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// CreateContainerdDirectories creates the directories of the embedded containerd server, and generates its configuration
 func CreateContainerdDirectories() error {
-	homeDir, err := os.UserHomeDir()
+	dirs, err := containerdDirs()
 	if err != nil {
 		return err
 	}
-	baseDir := filepath.Join(homeDir, ".containerd")
-	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
-		if err = os.Mkdir(baseDir, os.ModePerm); err != nil {
-			return err
-		}
-	}
-	rootDir := filepath.Join(baseDir, "root")
-	if _, err := os.Stat(rootDir); os.IsNotExist(err) {
-		if err = os.Mkdir(rootDir, os.ModePerm); err != nil {
+	for _, dir := range []string{dirs.config, dirs.log, dirs.root, dirs.state} {
+		if err = os.MkdirAll(dir, 0700); err != nil {
 			return err
 		}
 	}
-	stateDir := filepath.Join(baseDir, "state")
-	if _, err := os.Stat(stateDir); os.IsNotExist(err) {
-		if err = os.Mkdir(stateDir, os.ModePerm); err != nil {
-			return err
-		}
-	}
-	configFile := filepath.Join(baseDir, "config.toml")
-	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		config, err := os.OpenFile(configFile, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
-		defer config.Close()
-		if err != nil {
-			return err
-		}
-		//root = "C:\\Users\\cvila\\.containerd\\root"
-		//state = "C:\\Users\\cvila\\.containerd\\state"
-		rootDir := strings.ReplaceAll(filepath.Join(baseDir, "root"), "\\", "\\\\")
-		stateDir := strings.ReplaceAll(filepath.Join(baseDir, "state"), "\\", "\\\\")
-		_, err = config.WriteString("root = \"" + rootDir + "\"\n")
-		_, err = config.WriteString("state = \"" + stateDir + "\"")
-		_, err = config.WriteString(containerdConfig)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return os.WriteFile(filepath.Join(dirs.config, "config.toml"), []byte(serverConfig(dirs)), 0644)
 }
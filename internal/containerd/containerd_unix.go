@@ -0,0 +1,137 @@
+//go:build !windows
+
+package containerd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const serverBinaryName = "containerd"
+
+// serverStopSignal stops the containerd server, letting it remove its socket
+var serverStopSignal = syscall.SIGTERM
+
+// containerdConfig only enables the snapshotters which do not need privileges nor specific file systems (native, and
+// overlayfs when the kernel allows it), so that the server can run rootless
+var containerdConfig = `
+version = 2
+root = {{root}}
+state = {{state}}
+plugin_dir = ""
+disabled_plugins = [ "io.containerd.grpc.v1.cri", "io.containerd.snapshotter.v1.aufs", "io.containerd.snapshotter.v1.btrfs", "io.containerd.snapshotter.v1.devmapper", "io.containerd.snapshotter.v1.zfs" ]
+required_plugins = []
+oom_score = 0
+
+[grpc]
+  address = {{address}}
+  tcp_address = ""
+  tcp_tls_cert = ""
+  tcp_tls_key = ""
+  uid = {{uid}}
+  gid = {{gid}}
+  max_recv_message_size = 16777216
+  max_send_message_size = 16777216
+
+[ttrpc]
+  address = ""
+  uid = {{uid}}
+  gid = {{gid}}
+
+[debug]
+  address = ""
+  uid = {{uid}}
+  gid = {{gid}}
+  level = ""
+
+[metrics]
+  address = ""
+  grpc_histogram = false
+
+[cgroup]
+  path = ""
+
+[timeouts]
+  "io.containerd.timeout.bolt.open" = "10s"
+  "io.containerd.timeout.shim.cleanup" = "5s"
+  "io.containerd.timeout.shim.load" = "5s"
+  "io.containerd.timeout.shim.shutdown" = "3s"
+  "io.containerd.timeout.task.state" = "2s"
+
+[plugins]
+  [plugins."io.containerd.gc.v1.scheduler"]
+    pause_threshold = 0.02
+    deletion_threshold = 0
+    mutation_threshold = 100
+    schedule_delay = "0s"
+    startup_delay = "100ms"
+  [plugins."io.containerd.internal.v1.opt"]
+    path = {{opt}}
+  [plugins."io.containerd.internal.v1.restart"]
+    interval = "10s"
+  [plugins."io.containerd.metadata.v1.bolt"]
+    content_sharing_policy = "shared"
+  [plugins."io.containerd.service.v1.diff-service"]
+    default = ["walking"]
+`
+
+// xdgDir returns the directory given by an XDG base directory variable, or its default under the user home
+func xdgDir(variable string, defaultDir ...string) (string, error) {
+	if dir := os.Getenv(variable); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{homeDir}, defaultDir...)...), nil
+}
+
+// containerdDirs returns the directories of the embedded containerd server following the XDG base directory
+// specification: configuration in XDG_CONFIG_HOME, content in XDG_DATA_HOME, logs in XDG_STATE_HOME, and socket
+// in XDG_RUNTIME_DIR (or a private temporary directory when not set)
+func containerdDirs() (*serverDirs, error) {
+	configDir, err := xdgDir("XDG_CONFIG_HOME", ".config")
+	if err != nil {
+		return nil, err
+	}
+	dataDir, err := xdgDir("XDG_DATA_HOME", ".local", "share")
+	if err != nil {
+		return nil, err
+	}
+	stateDir, err := xdgDir("XDG_STATE_HOME", ".local", "state")
+	if err != nil {
+		return nil, err
+	}
+	runtimeDir := filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "helm-image")
+	if !filepath.IsAbs(runtimeDir) {
+		runtimeDir = filepath.Join(os.TempDir(), fmt.Sprintf("helm-image-%d", os.Getuid()))
+	}
+	return &serverDirs{
+		config: filepath.Join(configDir, "helm-image", "containerd"),
+		log:    filepath.Join(stateDir, "helm-image", "containerd"),
+		root:   filepath.Join(dataDir, "helm-image", "containerd"),
+		state:  filepath.Join(runtimeDir, "containerd"),
+	}, nil
+}
+
+// serverAddress returns the unix socket of the embedded containerd server
+func serverAddress(dirs *serverDirs) string {
+	return filepath.Join(dirs.state, "containerd.sock")
+}
+
+// serverConfig returns the configuration of the embedded containerd server, its sockets being owned by the current user
+func serverConfig(dirs *serverDirs) string {
+	return strings.NewReplacer(
+		"{{root}}", tomlString(dirs.root),
+		"{{state}}", tomlString(dirs.state),
+		"{{address}}", tomlString(serverAddress(dirs)),
+		"{{opt}}", tomlString(filepath.Join(dirs.root, "opt")),
+		"{{uid}}", strconv.Itoa(os.Getuid()),
+		"{{gid}}", strconv.Itoa(os.Getgid()),
+	).Replace(containerdConfig)
+}
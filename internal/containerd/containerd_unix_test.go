@@ -0,0 +1,140 @@
+//go:build !windows
+
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRegistry serves the blobs and manifests of images in the repository lib/app, manifests being given by tag or digest
+type testRegistry struct {
+	blobs map[digest.Digest]ocispec.Descriptor
+	data  map[digest.Digest][]byte
+	tags  map[string]digest.Digest
+}
+
+func (r *testRegistry) add(mediaType string, data []byte, platform *ocispec.Platform) ocispec.Descriptor {
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+		Platform:  platform,
+	}
+	r.blobs[desc.Digest] = desc
+	r.data[desc.Digest] = data
+	return desc
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/v2/lib/app/")
+	ref := path[strings.Index(path, "/")+1:]
+	dgst, ok := r.tags[ref]
+	if !ok {
+		dgst = digest.Digest(ref)
+	}
+	desc, ok := r.blobs[dgst]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", desc.MediaType)
+	w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+	w.Header().Set("Content-Length", fmt.Sprint(desc.Size))
+	if req.Method == http.MethodGet {
+		_, _ = w.Write(r.data[dgst])
+	}
+}
+
+func TestPullAndSavePlatforms(t *testing.T) {
+	dir := t.TempDir()
+	for _, env := range []string{"XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_RUNTIME_DIR"} {
+		t.Setenv(env, filepath.Join(dir, env))
+	}
+	r := &testRegistry{
+		blobs: map[digest.Digest]ocispec.Descriptor{},
+		data:  map[digest.Digest][]byte{},
+		tags:  map[string]digest.Digest{},
+	}
+	var manifests []ocispec.Descriptor
+	for _, arch := range []string{"amd64", "arm64", "s390x"} {
+		config := r.add(ocispec.MediaTypeImageConfig, []byte(`{"os":"linux","architecture":"`+arch+`"}`), nil)
+		layer := r.add(ocispec.MediaTypeImageLayerGzip, []byte("layer "+arch), nil)
+		manifest := ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest, Config: config, Layers: []ocispec.Descriptor{layer}}
+		manifest.SchemaVersion = 2
+		data, _ := json.Marshal(manifest)
+		manifests = append(manifests, r.add(ocispec.MediaTypeImageManifest, data, &ocispec.Platform{OS: "linux", Architecture: arch}))
+	}
+	index := ocispec.Index{MediaType: ocispec.MediaTypeImageIndex, Manifests: manifests}
+	index.SchemaVersion = 2
+	data, _ := json.Marshal(index)
+	r.tags["1.0"] = r.add(ocispec.MediaTypeImageIndex, data, nil).Digest
+	server := httptest.NewTLSServer(r)
+	defer server.Close()
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = server.Client().Transport
+	defer func() {
+		http.DefaultClient.Transport = transport
+	}()
+
+	image := strings.TrimPrefix(server.URL, "https://") + "/lib/app:1.0"
+	selected, err := ParsePlatforms([]string{"linux/amd64", "linux/arm64"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, closeClient, err := LocalClient(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClient()
+	ctx := namespaces.WithNamespace(context.Background(), "default")
+	credentials := func(string) func(string) (string, string, error) {
+		return nil
+	}
+	err = PullImages(ctx, client, credentials, []string{image}, selected, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(dir, "images.tar")
+	err = SaveImages(ctx, client, []string{image}, fileName, selected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := OpenArchive(context.Background(), fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	for i, manifest := range manifests {
+		_, err := a.store.Info(context.Background(), manifest.Digest)
+		if saved := err == nil; saved != (i < 2) {
+			t.Errorf("manifest of %s saved: %t", manifest.Platform.Architecture, saved)
+		}
+	}
+}
+
+func TestLocalClientInUse(t *testing.T) {
+	dir := t.TempDir()
+	for _, env := range []string{"XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_RUNTIME_DIR"} {
+		t.Setenv(env, filepath.Join(dir, env))
+	}
+	_, closeClient, err := LocalClient(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClient()
+	_, _, err = LocalClient(false)
+	if err == nil || !strings.Contains(err.Error(), "is in use by another process") {
+		t.Fatalf("expected local cache in use, got %v", err)
+	}
+}
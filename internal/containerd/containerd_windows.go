@@ -0,0 +1,105 @@
+package containerd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const serverBinaryName = "containerd.exe"
+
+// serverStopSignal stops the containerd server, Windows processes only supporting to be killed
+var serverStopSignal = os.Kill
+
+var containerdConfig = `
+version = 2
+root = {{root}}
+state = {{state}}
+plugin_dir = ""
+disabled_plugins = [ "io.containerd.grpc.v1.cri" ]
+required_plugins = []
+oom_score = 0
+
+[grpc]
+  address = {{address}}
+  tcp_address = ""
+  tcp_tls_cert = ""
+  tcp_tls_key = ""
+  uid = 0
+  gid = 0
+  max_recv_message_size = 16777216
+  max_send_message_size = 16777216
+
+[ttrpc]
+  address = ""
+  uid = 0
+  gid = 0
+
+[debug]
+  address = ""
+  uid = 0
+  gid = 0
+  level = ""
+
+[metrics]
+  address = ""
+  grpc_histogram = false
+
+[cgroup]
+  path = ""
+
+[timeouts]
+  "io.containerd.timeout.bolt.open" = "10s"
+  "io.containerd.timeout.shim.cleanup" = "5s"
+  "io.containerd.timeout.shim.load" = "5s"
+  "io.containerd.timeout.shim.shutdown" = "3s"
+  "io.containerd.timeout.task.state" = "2s"
+
+[plugins]
+  [plugins."io.containerd.gc.v1.scheduler"]
+    pause_threshold = 0.02
+    deletion_threshold = 0
+    mutation_threshold = 100
+    schedule_delay = "0s"
+    startup_delay = "100ms"
+  [plugins."io.containerd.internal.v1.opt"]
+    path = {{opt}}
+  [plugins."io.containerd.internal.v1.restart"]
+    interval = "10s"
+  [plugins."io.containerd.metadata.v1.bolt"]
+    content_sharing_policy = "shared"
+  [plugins."io.containerd.runtime.v2.task"]
+    platforms = ["windows/amd64", "linux/amd64"]
+  [plugins."io.containerd.service.v1.diff-service"]
+    default = ["windows", "windows-lcow"]
+`
+
+// containerdDirs returns the directories of the embedded containerd server, all under .containerd in the user home
+func containerdDirs() (*serverDirs, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	baseDir := filepath.Join(homeDir, ".containerd")
+	return &serverDirs{
+		config: baseDir,
+		log:    baseDir,
+		root:   filepath.Join(baseDir, "root"),
+		state:  filepath.Join(baseDir, "state"),
+	}, nil
+}
+
+// serverAddress returns the named pipe of the embedded containerd server
+func serverAddress(dirs *serverDirs) string {
+	return "\\\\.\\pipe\\containerd-containerd"
+}
+
+// serverConfig returns the configuration of the embedded containerd server
+func serverConfig(dirs *serverDirs) string {
+	return strings.NewReplacer(
+		"{{root}}", tomlString(dirs.root),
+		"{{state}}", tomlString(dirs.state),
+		"{{address}}", tomlString(serverAddress(dirs)),
+		"{{opt}}", tomlString(filepath.Join(dirs.root, "opt")),
+	).Replace(containerdConfig)
+}
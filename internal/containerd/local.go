@@ -0,0 +1,92 @@
+package containerd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/metadata"
+	"github.com/containerd/containerd/snapshots"
+	bolt "go.etcd.io/bbolt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LocalClient returns a client working in-process, without any containerd server, on a persistent content store
+// and metadata database laid out like the ones of the embedded containerd server, so that both share their images.
+// The returned function closes the database, once unreferenced content has been garbage collected
+func LocalClient(debug bool) (*containerd.Client, func(), error) {
+	err := CreateContainerdDirectories()
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating containerd directories: %w", err)
+	}
+	dirs, err := containerdDirs()
+	if err != nil {
+		return nil, nil, err
+	}
+	clientLogFile, err := os.OpenFile(filepath.Join(dirs.log, "client.log"), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileLog = log.New(clientLogFile, "container", log.LstdFlags)
+	if debug {
+		log.Printf("Opening local content store in %s...\n", dirs.root)
+	}
+	store, err := local.NewStore(filepath.Join(dirs.root, "io.containerd.content.v1.content"))
+	if err != nil {
+		clientLogFile.Close()
+		return nil, nil, fmt.Errorf("opening content store: %w", err)
+	}
+	metadataDir := filepath.Join(dirs.root, "io.containerd.metadata.v1.bolt")
+	err = os.MkdirAll(metadataDir, 0700)
+	if err != nil {
+		clientLogFile.Close()
+		return nil, nil, err
+	}
+	// the database is locked while used by another process, e.g. the embedded containerd server, whose content
+	// would otherwise be garbage collected under its feet
+	bdb, err := bolt.Open(filepath.Join(metadataDir, "meta.db"), 0644, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		clientLogFile.Close()
+		return nil, nil, fmt.Errorf("local cache %s is in use by another process, e.g. an embedded containerd server", dirs.root)
+	}
+	if err != nil {
+		clientLogFile.Close()
+		return nil, nil, fmt.Errorf("opening metadata database: %w", err)
+	}
+	// content is shared between namespaces, like in the embedded containerd server
+	db := metadata.NewDB(bdb, store, map[string]snapshots.Snapshotter{})
+	err = db.Init(context.Background())
+	if err != nil {
+		bdb.Close()
+		clientLogFile.Close()
+		return nil, nil, fmt.Errorf("initializing metadata database: %w", err)
+	}
+	client, err := containerd.New("", containerd.WithServices(
+		containerd.WithContentStore(db.ContentStore()),
+		containerd.WithImageStore(metadata.NewImageStore(db)),
+		containerd.WithLeasesService(metadata.NewLeaseManager(db)),
+	))
+	if err != nil {
+		bdb.Close()
+		clientLogFile.Close()
+		return nil, nil, err
+	}
+	closeDB := func() {
+		if debug {
+			log.Println("Closing local content store...")
+		}
+		closeClient(client)
+		if _, err := db.GarbageCollect(context.Background()); err != nil {
+			log.Printf("Warning: cannot garbage collect local content store: %s\n", err)
+		}
+		if err := bdb.Close(); err != nil {
+			log.Printf("Warning: cannot close metadata database: %s\n", err)
+		}
+		clientLogFile.Close()
+	}
+	return client, closeDB, nil
+}
@@ -0,0 +1,222 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/labels"
+	"github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	"github.com/gemalto/helm-image/internal/registry"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mirror copies images between registries, remembering the repositories where blobs have been pushed
+// so that they can be mounted instead of uploaded again
+type Mirror struct {
+	credentials registry.Credentials
+	plainHTTP   bool
+	source      remotes.Resolver
+	pushed      map[digest.Digest]map[string]struct{}
+	mu          sync.Mutex
+	verbose     bool
+}
+
+// NewMirror returns a mirror authenticating with the given credentials, and reaching the target registry
+// through plain HTTP if requested
+func NewMirror(credentials registry.Credentials, plainHTTP bool, verbose bool) *Mirror {
+	return &Mirror{
+		credentials: credentials,
+		plainHTTP:   plainHTTP,
+		source:      NewResolver(credentials, false),
+		pushed:      map[digest.Digest]map[string]struct{}{},
+		verbose:     verbose,
+	}
+}
+
+func (m *Mirror) addPushed(dgst digest.Digest, repository string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.pushed[dgst]; !ok {
+		m.pushed[dgst] = map[string]struct{}{}
+	}
+	m.pushed[dgst][repository] = struct{}{}
+}
+
+// mountSources annotates a blob descriptor with the repositories of the target registry where it has already been pushed
+func (m *Mirror) mountSources(desc ocispec.Descriptor, targetRef reference.Named) ocispec.Descriptor {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var repositories []string
+	for repository := range m.pushed[desc.Digest] {
+		repositories = append(repositories, repository)
+	}
+	if len(repositories) == 0 {
+		return desc
+	}
+	sort.Strings(repositories)
+	host := reference.Domain(targetRef)
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	annotations := map[string]string{}
+	for key, value := range desc.Annotations {
+		annotations[key] = value
+	}
+	annotations[labels.LabelDistributionSource+"."+host] = strings.Join(repositories, ",")
+	desc.Annotations = annotations
+	return desc
+}
+
+func isManifestType(mediaType string) bool {
+	switch mediaType {
+	case images.MediaTypeDockerSchema2Manifest, ocispec.MediaTypeImageManifest:
+		return true
+	}
+	return false
+}
+
+// children returns the descriptors referenced by a manifest or an index
+func children(desc ocispec.Descriptor, data []byte) ([]ocispec.Descriptor, error) {
+	switch {
+	case images.IsIndexType(desc.MediaType):
+		var index ocispec.Index
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, err
+		}
+		return index.Manifests, nil
+	case isManifestType(desc.MediaType):
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, err
+		}
+		return append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...), nil
+	}
+	return nil, fmt.Errorf("unsupported media type %s", desc.MediaType)
+}
+
+// push pushes a descriptor with the content returned by fetch, and returns false when the target registry already has it
+func push(ctx context.Context, pusher remotes.Pusher, desc ocispec.Descriptor, fetch func() (io.ReadCloser, error)) (bool, error) {
+	writer, err := pusher.Push(ctx, desc)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer writer.Close()
+	reader, err := fetch()
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	_, err = io.Copy(writer, reader)
+	if err != nil {
+		return false, err
+	}
+	err = writer.Commit(ctx, desc.Size, desc.Digest)
+	if err != nil && !errdefs.IsAlreadyExists(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// copy pushes a descriptor and all its children, children being pushed first as registries reject manifests
+// referencing unknown blobs
+func (m *Mirror) copy(ctx context.Context, fetcher remotes.Fetcher, pusher remotes.Pusher, desc ocispec.Descriptor, targetRef reference.Named) error {
+	if !images.IsIndexType(desc.MediaType) && !isManifestType(desc.MediaType) {
+		pushed, err := push(ctx, pusher, m.mountSources(desc, targetRef), func() (io.ReadCloser, error) {
+			if m.verbose {
+				fmt.Printf("%s: Copying\n", desc.Digest.Encoded()[:12])
+			}
+			return fetcher.Fetch(ctx, desc)
+		})
+		if err != nil {
+			return fmt.Errorf("copying %s: %w", desc.Digest, err)
+		}
+		if !pushed && m.verbose {
+			fmt.Printf("%s: Already exists\n", desc.Digest.Encoded()[:12])
+		}
+		m.addPushed(desc.Digest, reference.Path(targetRef))
+		return nil
+	}
+	reader, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return fmt.Errorf("fetching %s: %w", desc.Digest, err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("fetching %s: %w", desc.Digest, err)
+	}
+	if digest.FromBytes(data) != desc.Digest {
+		return fmt.Errorf("fetching %s: digest mismatch", desc.Digest)
+	}
+	descs, err := children(desc, data)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", desc.Digest, err)
+	}
+	for _, child := range descs {
+		err = m.copy(ctx, fetcher, pusher, child, targetRef)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = push(ctx, pusher, desc, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	if err != nil {
+		return fmt.Errorf("pushing %s: %w", desc.Digest, err)
+	}
+	return nil
+}
+
+// pushReference returns the reference to push a root manifest to: pushing to name:tag@digest pushes the root manifest
+// under the tag, and the other manifests under their digest only
+func pushReference(targetRef reference.Named, dgst digest.Digest) string {
+	if tagged, ok := targetRef.(reference.Tagged); ok {
+		return reference.TrimNamed(targetRef).String() + ":" + tagged.Tag() + "@" + dgst.String()
+	}
+	return reference.TrimNamed(targetRef).String() + "@" + dgst.String()
+}
+
+// MirrorImage copies an image, with all its platforms, from its registry to the target reference
+func (m *Mirror) MirrorImage(ctx context.Context, sourceName string, targetName string) error {
+	fmt.Printf("Mirroring image %s to %s...\n", sourceName, targetName)
+	sourceRef, err := registry.ParseImageRef(sourceName)
+	if err != nil {
+		return err
+	}
+	targetRef, err := registry.ParseImageRef(targetName)
+	if err != nil {
+		return err
+	}
+	name, desc, err := m.source.Resolve(ctx, sourceRef.String())
+	if err != nil {
+		return fmt.Errorf("resolving %s: %w", sourceName, err)
+	}
+	fetcher, err := m.source.Fetcher(ctx, name)
+	if err != nil {
+		return err
+	}
+	pushRef := pushReference(targetRef, desc.Digest)
+	// push status is tracked by digest in a resolver, which would prevent pushing a blob in several repositories
+	pusher, err := NewResolver(m.credentials, m.plainHTTP).Pusher(ctx, pushRef)
+	if err != nil {
+		return err
+	}
+	err = m.copy(ctx, fetcher, pusher, desc, targetRef)
+	if err != nil {
+		return fmt.Errorf("mirroring %s: %w", sourceName, err)
+	}
+	fmt.Printf("Successfully mirrored %s image\n", targetName)
+	return nil
+}
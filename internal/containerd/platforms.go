@@ -0,0 +1,214 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"log"
+	"strconv"
+)
+
+// Platforms selects the platforms of the images to pull and save, either some platforms or all of them
+type Platforms struct {
+	platforms []ocispec.Platform
+	all       bool
+}
+
+// ParsePlatforms parses platform specifiers like linux/arm64 (can be separated with commas), linux platform of the
+// host being selected when none is given
+func ParsePlatforms(specs []string, all bool) (*Platforms, error) {
+	if all && len(specs) > 0 {
+		return nil, fmt.Errorf("--platform cannot be used with --all-platforms")
+	}
+	p := &Platforms{
+		all: all,
+	}
+	if len(specs) == 0 {
+		specs = []string{"linux"}
+	}
+	for _, spec := range specs {
+		platform, err := platforms.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("parsing platform %s: %w", spec, err)
+		}
+		p.platforms = append(p.platforms, platform)
+	}
+	return p, nil
+}
+
+// All returns true when all platforms are selected
+func (p *Platforms) All() bool {
+	return p.all
+}
+
+// Matcher returns a matcher of the selected platforms
+func (p *Platforms) Matcher() platforms.MatchComparer {
+	if p.all {
+		return platforms.All
+	}
+	return platforms.Ordered(p.platforms...)
+}
+
+func (p *Platforms) String() string {
+	if p.all {
+		return "all platforms"
+	}
+	var s string
+	for i, platform := range p.platforms {
+		if i > 0 {
+			s = s + ","
+		}
+		s = s + platforms.Format(platform)
+	}
+	return s
+}
+
+// selectPlatforms returns the available platforms of an image matching the selected ones, warning for each
+// selected platform which is not available
+func (p *Platforms) selectPlatforms(imageName string, available []ocispec.Platform) []ocispec.Platform {
+	if p.all {
+		return available
+	}
+	var selected []ocispec.Platform
+	for _, platform := range p.platforms {
+		matcher := platforms.NewMatcher(platform)
+		found := false
+		for _, a := range available {
+			if matcher.Match(a) {
+				found = true
+				duplicate := false
+				for _, s := range selected {
+					if platforms.Format(s) == platforms.Format(a) {
+						duplicate = true
+					}
+				}
+				if !duplicate {
+					selected = append(selected, a)
+				}
+			}
+		}
+		if !found {
+			log.Printf("Warning: image %s has no manifest for platform %s\n", imageName, platforms.Format(platform))
+		}
+	}
+	return selected
+}
+
+// SelectPlatformImages returns the platform images of an index matching the selected platforms, in the order of the
+// selected platforms, warning for each selected platform which is not available
+func (p *Platforms) SelectPlatformImages(imageName string, platformImages []PlatformImage) ([]PlatformImage, error) {
+	var available []ocispec.Platform
+	var availableImages []PlatformImage
+	for _, platformImage := range platformImages {
+		platform, err := platforms.Parse(platformImage.Platform)
+		if err != nil {
+			return nil, fmt.Errorf("parsing platform %s of %s: %w", platformImage.Platform, imageName, err)
+		}
+		// attestation manifests of buildkit are not images of a platform
+		if platform.OS == "unknown" {
+			continue
+		}
+		available = append(available, platform)
+		availableImages = append(availableImages, platformImage)
+	}
+	var selected []PlatformImage
+	for _, platform := range p.selectPlatforms(imageName, available) {
+		for i, a := range available {
+			if platforms.Format(a) == platforms.Format(platform) {
+				selected = append(selected, availableImages[i])
+				break
+			}
+		}
+	}
+	return selected, nil
+}
+
+// selectManifests returns the descriptor of an image restricted to the selected platforms: an index is replaced
+// by a new index referencing the manifests of the selected platforms only, so that it remains valid once exported
+func (p *Platforms) selectManifests(ctx context.Context, store content.Store, imageName string, desc ocispec.Descriptor) (ocispec.Descriptor, error) {
+	if !images.IsIndexType(desc.MediaType) {
+		available, err := images.Platforms(ctx, store, desc)
+		if err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("reading platform of %s: %w", imageName, err)
+		}
+		p.selectPlatforms(imageName, available)
+		return desc, nil
+	}
+	data, err := content.ReadBlob(ctx, store, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	var index ocispec.Index
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("parsing index of %s: %w", imageName, err)
+	}
+	if p.all {
+		return desc, nil
+	}
+	var available []ocispec.Platform
+	for _, manifest := range index.Manifests {
+		if manifest.Platform != nil {
+			available = append(available, *manifest.Platform)
+		}
+	}
+	selected := p.selectPlatforms(imageName, available)
+	var manifests []ocispec.Descriptor
+	for _, manifest := range index.Manifests {
+		if manifest.Platform == nil {
+			continue
+		}
+		for _, platform := range selected {
+			if platforms.Format(*manifest.Platform) == platforms.Format(platform) {
+				manifests = append(manifests, manifest)
+				break
+			}
+		}
+	}
+	if len(manifests) == 0 {
+		return ocispec.Descriptor{}, fmt.Errorf("image %s has no manifest for %s", imageName, p)
+	}
+	if len(manifests) == len(index.Manifests) {
+		return desc, nil
+	}
+	index.Manifests = manifests
+	data, err = json.Marshal(index)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	selectedDesc := ocispec.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	// labels keep the manifests from being garbage collected with the new index
+	labels := map[string]string{}
+	for i, manifest := range manifests {
+		labels["containerd.io/gc.ref.content.m."+strconv.Itoa(i)] = manifest.Digest.String()
+	}
+	err = content.WriteBlob(ctx, store, selectedDesc.Digest.String(), bytes.NewReader(data), selectedDesc, content.WithLabels(labels))
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("writing index of %s: %w", imageName, err)
+	}
+	return selectedDesc, nil
+}
+
+// checkManifests returns an error when a manifest of the selected platforms of an image, or one of its blobs, is
+// missing from the content store, so that no incomplete archive is written
+func (p *Platforms) checkManifests(ctx context.Context, store content.Store, imageName string, desc ocispec.Descriptor) error {
+	exists := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		_, err := store.Info(ctx, desc.Digest)
+		if errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("image %s has no %s %s in local cache, please pull it again for %s", imageName, desc.MediaType, desc.Digest, p)
+		}
+		return nil, err
+	})
+	return images.Walk(ctx, images.Handlers(exists, images.FilterPlatforms(images.ChildrenHandler(store), p.Matcher())), desc)
+}
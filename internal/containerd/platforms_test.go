@@ -0,0 +1,251 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	tests := []struct {
+		name     string
+		specs    []string
+		all      bool
+		expected string
+		err      string
+	}{
+		{
+			name:     "linux by default",
+			expected: platforms.Format(platforms.MustParse("linux")),
+		},
+		{
+			name:     "several platforms",
+			specs:    []string{"linux/amd64", "linux/arm64"},
+			expected: "linux/amd64,linux/arm64",
+		},
+		{
+			name:     "all platforms",
+			all:      true,
+			expected: "all platforms",
+		},
+		{
+			name:  "platforms with all platforms",
+			specs: []string{"linux/amd64"},
+			all:   true,
+			err:   "cannot be used with --all-platforms",
+		},
+		{
+			name:  "invalid platform",
+			specs: []string{"linux/amd64/v8/x"},
+			err:   "parsing platform linux/amd64/v8/x",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := ParsePlatforms(test.specs, test.all)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.String() != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, p)
+			}
+		})
+	}
+}
+
+func TestSelectPlatforms(t *testing.T) {
+	available := []ocispec.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		{OS: "windows", Architecture: "amd64"},
+	}
+	tests := []struct {
+		name     string
+		specs    []string
+		all      bool
+		expected []string
+	}{
+		{
+			name:     "one platform",
+			specs:    []string{"linux/amd64"},
+			expected: []string{"linux/amd64"},
+		},
+		{
+			name:     "platform without variant",
+			specs:    []string{"linux/arm64"},
+			expected: []string{"linux/arm64/v8"},
+		},
+		{
+			name:     "duplicate platforms",
+			specs:    []string{"linux/arm64", "linux/arm64/v8"},
+			expected: []string{"linux/arm64/v8"},
+		},
+		{
+			name:     "missing platform",
+			specs:    []string{"linux/amd64", "linux/s390x"},
+			expected: []string{"linux/amd64"},
+		},
+		{
+			name:     "all platforms",
+			all:      true,
+			expected: []string{"linux/amd64", "linux/arm64/v8", "windows/amd64"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := ParsePlatforms(test.specs, test.all)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var selected []string
+			for _, platform := range p.selectPlatforms("test", available) {
+				selected = append(selected, platforms.Format(platform))
+			}
+			if !reflect.DeepEqual(selected, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, selected)
+			}
+		})
+	}
+}
+
+func TestSelectPlatformImages(t *testing.T) {
+	platformImages := []PlatformImage{
+		{Platform: "linux/amd64", Digest: digest.FromString("amd64")},
+		{Platform: "linux/arm64/v8", Digest: digest.FromString("arm64")},
+		{Platform: "unknown/unknown", Digest: digest.FromString("attestation")},
+		{Platform: "linux/s390x", Digest: digest.FromString("s390x")},
+	}
+	tests := []struct {
+		name     string
+		specs    []string
+		all      bool
+		expected []string
+	}{
+		{
+			name:     "platforms in selected order",
+			specs:    []string{"linux/s390x", "linux/amd64"},
+			expected: []string{"linux/s390x", "linux/amd64"},
+		},
+		{
+			name:     "missing platform",
+			specs:    []string{"linux/arm64", "windows/amd64"},
+			expected: []string{"linux/arm64/v8"},
+		},
+		{
+			name:     "all platforms without attestations",
+			all:      true,
+			expected: []string{"linux/amd64", "linux/arm64/v8", "linux/s390x"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := ParsePlatforms(test.specs, test.all)
+			if err != nil {
+				t.Fatal(err)
+			}
+			selected, err := p.SelectPlatformImages("test", platformImages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var found []string
+			for _, platformImage := range selected {
+				if platformImage.Digest != digest.FromString(strings.Split(platformImage.Platform, "/")[1]) {
+					t.Errorf("unexpected digest %s for %s", platformImage.Digest, platformImage.Platform)
+				}
+				found = append(found, platformImage.Platform)
+			}
+			if !reflect.DeepEqual(found, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, found)
+			}
+		})
+	}
+}
+
+func writeTestBlob(t *testing.T, store content.Store, mediaType string, data []byte, platform *ocispec.Platform) ocispec.Descriptor {
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+		Platform:  platform,
+	}
+	if store != nil {
+		err := content.WriteBlob(context.Background(), store, desc.Digest.String(), bytes.NewReader(data), desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return desc
+}
+
+func TestCheckManifests(t *testing.T) {
+	ctx := context.Background()
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the arm64 manifest is referenced by the index, but has not been pulled
+	manifest := func(store content.Store, arch string) ocispec.Descriptor {
+		platform := &ocispec.Platform{OS: "linux", Architecture: arch}
+		config := writeTestBlob(t, store, ocispec.MediaTypeImageConfig, []byte(`{"os":"linux","architecture":"`+arch+`"}`), nil)
+		layer := writeTestBlob(t, store, ocispec.MediaTypeImageLayerGzip, []byte("layer "+arch), nil)
+		data, _ := json.Marshal(ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest, Config: config, Layers: []ocispec.Descriptor{layer}})
+		return writeTestBlob(t, store, ocispec.MediaTypeImageManifest, data, platform)
+	}
+	manifests := []ocispec.Descriptor{manifest(store, "amd64"), manifest(nil, "arm64")}
+	data, _ := json.Marshal(ocispec.Index{MediaType: ocispec.MediaTypeImageIndex, Manifests: manifests})
+	index := writeTestBlob(t, store, ocispec.MediaTypeImageIndex, data, nil)
+
+	tests := []struct {
+		name  string
+		specs []string
+		all   bool
+		err   string
+	}{
+		{
+			name:  "pulled platform",
+			specs: []string{"linux/amd64"},
+		},
+		{
+			name:  "platform not pulled",
+			specs: []string{"linux/amd64", "linux/arm64"},
+			err:   "has no " + ocispec.MediaTypeImageManifest + " " + manifests[1].Digest.String(),
+		},
+		{
+			name: "all platforms",
+			all:  true,
+			err:  "has no " + ocispec.MediaTypeImageManifest + " " + manifests[1].Digest.String(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := ParsePlatforms(test.specs, test.all)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = p.checkManifests(ctx, store, "test", index)
+			if len(test.err) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -1,3 +1,5 @@
+//go:build !windows
+
 package credentials
 
 func GetAuthFromVault(repo string) (string, string, error) {
@@ -6,9 +6,13 @@ import (
 	"os/exec"
 )
 
-func Pull(image string, debug bool) error {
+// Pull pulls an image with the docker CLI, for the given platform if not empty
+func Pull(image string, platform string, debug bool) error {
 	dockerPath := "docker"
 	var myargs = []string{"pull", image}
+	if len(platform) > 0 {
+		myargs = []string{"pull", "--platform", platform, image}
+	}
 	if debug {
 		log.Printf("Running %s %v\n", dockerPath, myargs)
 	}
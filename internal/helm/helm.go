@@ -1,67 +1,106 @@
 package helm
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
+	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/engine"
 	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
 	"log"
 	"os"
-	"os/exec"
 	"reflect"
+	"strings"
 )
 
 type Dependency struct {
-	Name   string
-	Weight int
+	Name       string
+	Weight     int
+	Conditions []string
+	Tags       []string
 }
 
+// NOTES.txt is rendered like other templates, but is neither a hook nor a resource
+const notesFileSuffix = "NOTES.txt"
+
 var httpProvider = getter.Provider{
 	Schemes: []string{"http", "https"},
 	New:     getter.NewHTTPGetter,
 }
 
-func Template(helmPath string, manifestDir string, namespace string, chartPath string, valueFiles []string, valuesSet []string, valuesSetString []string, valuesSetFile []string, debug bool) error {
-	// Prepare parameters...
-	var myargs = []string{"template", chartPath, "--disable-openapi-validation", "--output-dir", manifestDir, "--namespace", namespace}
-
-	for _, v := range valuesSet {
-		myargs = append(myargs, "--set")
-		myargs = append(myargs, v)
+// Template renders in-process the templates of a chart, and returns the rendered manifests indexed by template path
+func Template(chartPath string, namespace string, valueOpts *values.Options, debug bool) (map[string]string, error) {
+	chart, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	providedValues, err := valueOpts.MergeValues(getter.Providers{httpProvider})
+	if err != nil {
+		return nil, fmt.Errorf("merging values from CLI flags: %w", err)
 	}
-	for _, v := range valuesSetString {
-		myargs = append(myargs, "--set-string")
-		myargs = append(myargs, v)
+	if err := chartutil.ProcessDependencies(chart, providedValues); err != nil {
+		return nil, fmt.Errorf("processing dependencies: %w", err)
 	}
-	for _, v := range valuesSetFile {
-		myargs = append(myargs, "--set-file")
-		myargs = append(myargs, v)
+	options := chartutil.ReleaseOptions{
+		Name:      "release-name",
+		Namespace: namespace,
+		Revision:  1,
+		IsInstall: true,
 	}
-	for _, v := range valueFiles {
-		myargs = append(myargs, "-f")
-		myargs = append(myargs, v)
+	renderValues, err := chartutil.ToRenderValues(chart, providedValues, options, chartutil.DefaultCapabilities.Copy())
+	if err != nil {
+		return nil, fmt.Errorf("computing render values: %w", err)
 	}
-
-	// Run the upgrade command
 	if debug {
-		log.Printf("Running %s %v\n", helmPath, myargs)
-	}
-	cmd := exec.Command(helmPath, myargs...)
-	cmdOutput := &bytes.Buffer{}
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = cmdOutput
-	err := cmd.Run()
-	output := cmdOutput.Bytes()
-	if debug {
-		log.Printf("Helm returned: \n%s\n", string(output))
+		log.Printf("Rendering templates of %s...\n", chart.Name())
+	}
+	manifests, err := engine.Render(chart, renderValues)
+	if err != nil {
+		return nil, fmt.Errorf("rendering templates: %w", err)
 	}
+	for name, content := range manifests {
+		if strings.HasSuffix(name, notesFileSuffix) || len(strings.TrimSpace(content)) == 0 {
+			delete(manifests, name)
+		}
+	}
+	return manifests, nil
+}
+
+// LocateChart returns the local path of a chart, after downloading it when given as a repo/chart reference, an URL or an OCI reference
+func LocateChart(name string, chartPathOpts *action.ChartPathOptions, devel bool, debug bool) (string, error) {
+	settings := cli.New()
+	settings.Debug = debug
+	registryClient, err := registry.NewClient(
+		registry.ClientOptDebug(debug),
+		registry.ClientOptEnableCache(true),
+		registry.ClientOptWriter(os.Stderr),
+		registry.ClientOptCredentialsFile(settings.RegistryConfig),
+	)
+	if err != nil {
+		return "", fmt.Errorf("creating registry client: %w", err)
+	}
+	install := action.NewInstall(&action.Configuration{})
+	install.ChartPathOptions = *chartPathOpts
+	install.SetRegistryClient(registryClient)
+	if devel && len(install.Version) == 0 {
+		if debug {
+			log.Println("Setting version to >0.0.0-0 to use development versions")
+		}
+		install.Version = ">0.0.0-0"
+	}
+	chartPath, err := install.ChartPathOptions.LocateChart(name, settings)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("locating chart %s: %w", name, err)
+	}
+	if debug {
+		log.Printf("Chart %s located at %s\n", name, chartPath)
 	}
-	return nil
+	return chartPath, nil
 }
 
 func MergeValues(chart *chart.Chart, valueOpts *values.Options) (chartutil.Values, error) {
@@ -113,6 +152,12 @@ func GetDependencies(chart *chart.Chart, values *chartutil.Values) ([]Dependency
 			}
 		}
 		dependencies[i].Weight = weight
+		for _, condition := range strings.Split(req.Condition, ",") {
+			if condition = strings.TrimSpace(condition); len(condition) > 0 {
+				dependencies[i].Conditions = append(dependencies[i].Conditions, condition)
+			}
+		}
+		dependencies[i].Tags = req.Tags
 	}
 	return dependencies, nil
 }
@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/docker/distribution/reference"
+	"strings"
+)
+
+// ParseImageRef parses an image reference and normalizes it, adding the latest tag when neither a tag nor a digest is given
+func ParseImageRef(imageName string) (reference.Named, error) {
+	imageRef, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return nil, err
+	}
+	if reference.IsNameOnly(imageRef) {
+		imageRef = reference.TagNameOnly(imageRef)
+	}
+	return imageRef, nil
+}
+
+// PathMapping relocates the repositories whose name starts with From under the To path
+type PathMapping struct {
+	From string
+	To   string
+}
+
+// ParsePathMappings parses from=to path mappings, from being a prefix of full repository names, e.g. docker.io/bitnami
+func ParsePathMappings(mappings []string) ([]PathMapping, error) {
+	var pathMappings []PathMapping
+	for _, mapping := range mappings {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid path mapping %q, shall be from=to", mapping)
+		}
+		pathMappings = append(pathMappings, PathMapping{
+			From: strings.TrimSuffix(parts[0], "/"),
+			To:   strings.Trim(parts[1], "/"),
+		})
+	}
+	return pathMappings, nil
+}
+
+// mirrorPath returns the path of a repository in the target registry, given by the mapping with the longest matching prefix,
+// or the path of the repository in its source registry when no mapping matches
+func mirrorPath(imageRef reference.Named, mappings []PathMapping) string {
+	name := imageRef.Name()
+	path := reference.Path(imageRef)
+	matched := -1
+	for _, mapping := range mappings {
+		if (name == mapping.From || strings.HasPrefix(name, mapping.From+"/")) && len(mapping.From) > matched {
+			matched = len(mapping.From)
+			path = strings.Trim(mapping.To+strings.TrimPrefix(name, mapping.From), "/")
+		}
+	}
+	return path
+}
+
+// MirrorImageRef returns the reference of an image relocated in a target registry, optionally followed by a path prefix,
+// the path of the image in its source registry being kept unless a path mapping matches
+func MirrorImageRef(imageRef reference.Named, target string, mappings []PathMapping) (reference.Named, error) {
+	name := strings.TrimSuffix(target, "/") + "/" + mirrorPath(imageRef, mappings)
+	mirrorRef, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return nil, fmt.Errorf("relocating %s in %s: %w", imageRef, target, err)
+	}
+	if tagged, ok := imageRef.(reference.Tagged); ok {
+		mirrorRef, err = reference.WithTag(mirrorRef, tagged.Tag())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if digested, ok := imageRef.(reference.Digested); ok {
+		mirrorRef, err = reference.WithDigest(mirrorRef, digested.Digest())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mirrorRef, nil
+}
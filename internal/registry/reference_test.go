@@ -0,0 +1,145 @@
+package registry
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePathMappings(t *testing.T) {
+	tests := []struct {
+		name     string
+		mappings []string
+		expected []PathMapping
+		err      string
+	}{
+		{
+			name: "no mapping",
+		},
+		{
+			name:     "slashes trimmed",
+			mappings: []string{"docker.io/bitnami/=/apps/bitnami/", "quay.io=mirror"},
+			expected: []PathMapping{{From: "docker.io/bitnami", To: "apps/bitnami"}, {From: "quay.io", To: "mirror"}},
+		},
+		{
+			name:     "mapping to the root",
+			mappings: []string{"docker.io/library="},
+			expected: []PathMapping{{From: "docker.io/library", To: ""}},
+		},
+		{
+			name:     "without target",
+			mappings: []string{"docker.io/bitnami"},
+			err:      `invalid path mapping "docker.io/bitnami", shall be from=to`,
+		},
+		{
+			name:     "without source",
+			mappings: []string{"=apps"},
+			err:      `invalid path mapping "=apps", shall be from=to`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mappings, err := ParsePathMappings(test.mappings)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(mappings, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, mappings)
+			}
+		})
+	}
+}
+
+func TestMirrorImageRef(t *testing.T) {
+	mappings, err := ParsePathMappings([]string{
+		"docker.io=hub",
+		"docker.io/bitnami=apps",
+		"docker.io/bitnami/redis=cache/redis",
+		"docker.io/library=",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		image    string
+		target   string
+		mappings []PathMapping
+		expected string
+	}{
+		{
+			name:     "source path kept",
+			image:    "quay.io/prometheus/prometheus:v2.45.0",
+			target:   "registry.example.com",
+			expected: "registry.example.com/prometheus/prometheus:v2.45.0",
+		},
+		{
+			name:     "target with path prefix",
+			image:    "nginx:1.25",
+			target:   "registry.example.com/mirror/",
+			expected: "registry.example.com/mirror/library/nginx:1.25",
+		},
+		{
+			name:     "tag and digest kept",
+			image:    "nginx:1.25@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			target:   "registry.example.com",
+			expected: "registry.example.com/library/nginx:1.25@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		},
+		{
+			name:     "longest prefix",
+			image:    "bitnami/redis-sentinel:7.0",
+			target:   "registry.example.com",
+			mappings: mappings,
+			expected: "registry.example.com/apps/redis-sentinel:7.0",
+		},
+		{
+			name:     "longest prefix on the full repository",
+			image:    "bitnami/redis:7.0",
+			target:   "registry.example.com",
+			mappings: mappings,
+			expected: "registry.example.com/cache/redis:7.0",
+		},
+		{
+			name:     "shortest prefix",
+			image:    "grafana/grafana:10.0.0",
+			target:   "registry.example.com",
+			mappings: mappings,
+			expected: "registry.example.com/hub/grafana/grafana:10.0.0",
+		},
+		{
+			name:     "mapping to the root",
+			image:    "nginx:1.25",
+			target:   "registry.example.com",
+			mappings: mappings,
+			expected: "registry.example.com/nginx:1.25",
+		},
+		{
+			name:     "no matching mapping",
+			image:    "quay.io/prometheus/prometheus:v2.45.0",
+			target:   "registry.example.com",
+			mappings: mappings,
+			expected: "registry.example.com/prometheus/prometheus:v2.45.0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			imageRef, err := ParseImageRef(test.image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mirrorRef, err := MirrorImageRef(imageRef, test.target, test.mappings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mirrorRef.String() != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, mirrorRef)
+			}
+		})
+	}
+}
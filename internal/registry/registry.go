@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"github.com/containerd/console"
+	"sync"
 )
 
 type registryCredentials struct {
@@ -13,6 +14,9 @@ type registryCredentials struct {
 
 var cache = map[string]*registryCredentials{}
 
+// mu serializes the prompts of concurrent pulls, so that credentials are only asked once for each registry
+var mu sync.Mutex
+
 type Credentials func(host string) func(string) (string, string, error)
 
 func prompt(show bool) (string, error) {
@@ -40,6 +44,8 @@ func AddAuthRegistry(host string, login string, password string) {
 func ConsoleCredentials(host string) func(string) (string, string, error) {
 	if _, ok := cache[host]; ok {
 		return func(host string) (string, string, error) {
+			mu.Lock()
+			defer mu.Unlock()
 			var err error
 			defer func() {
 				if r := recover(); r != nil {
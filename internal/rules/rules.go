@@ -0,0 +1,160 @@
+package rules
+
+import (
+	_ "embed"
+	"fmt"
+	"k8s.io/client-go/util/jsonpath"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+//go:embed builtin.yaml
+var builtinRules []byte
+
+type Image struct {
+	Path       string `json:"path,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+}
+
+type Rule struct {
+	APIVersion string  `json:"apiVersion"`
+	Kind       string  `json:"kind"`
+	Images     []Image `json:"images"`
+}
+
+type Rules struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Load returns the built-in rules followed by the rules defined in the given files
+func Load(files []string) (*Rules, error) {
+	rules, err := parse("built-in rules", builtinRules)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading rules file: %w", err)
+		}
+		fileRules, err := parse(file, content)
+		if err != nil {
+			return nil, err
+		}
+		rules.Rules = append(rules.Rules, fileRules.Rules...)
+	}
+	return rules, nil
+}
+
+func parse(name string, content []byte) (*Rules, error) {
+	rules := &Rules{}
+	err := yaml.UnmarshalStrict(content, rules)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+	for i, rule := range rules.Rules {
+		if len(rule.APIVersion) == 0 || len(rule.Kind) == 0 {
+			return nil, fmt.Errorf("parsing %s: rule %d shall define apiVersion and kind", name, i+1)
+		}
+		for _, image := range rule.Images {
+			if len(image.Path) == 0 && len(image.Repository) == 0 {
+				return nil, fmt.Errorf("parsing %s: images of rule %d for %s/%s shall define either path or repository", name, i+1, rule.APIVersion, rule.Kind)
+			}
+			if len(image.Path) > 0 && (len(image.Repository) > 0 || len(image.Tag) > 0) {
+				return nil, fmt.Errorf("parsing %s: images of rule %d for %s/%s cannot define both path and repository/tag", name, i+1, rule.APIVersion, rule.Kind)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func (r *Rule) matches(apiVersion string, kind string) bool {
+	if r.Kind != kind {
+		return false
+	}
+	if strings.HasSuffix(r.APIVersion, "/*") {
+		return strings.HasPrefix(apiVersion, strings.TrimSuffix(r.APIVersion, "*"))
+	}
+	return r.APIVersion == apiVersion
+}
+
+// Match returns all the rules applying to the given apiVersion and kind
+func (r *Rules) Match(apiVersion string, kind string) []Rule {
+	var rules []Rule
+	for _, rule := range r.Rules {
+		if rule.matches(apiVersion, kind) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func find(object map[string]interface{}, path string) ([]string, error) {
+	if !strings.HasPrefix(path, "{") {
+		if !strings.HasPrefix(path, ".") {
+			path = "." + path
+		}
+		path = "{" + path + "}"
+	}
+	parser := jsonpath.New(path).AllowMissingKeys(true)
+	err := parser.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("parsing path %s: %w", path, err)
+	}
+	results, err := parser.FindResults(object)
+	if err != nil {
+		return nil, fmt.Errorf("evaluating path %s: %w", path, err)
+	}
+	var values []string
+	for _, result := range results {
+		for _, value := range result {
+			if !value.IsValid() || !value.CanInterface() {
+				continue
+			}
+			if s, ok := value.Interface().(string); ok && len(s) > 0 {
+				values = append(values, s)
+			}
+		}
+	}
+	return values, nil
+}
+
+// Images returns the image references found in the given object, repositories being joined
+// with their tags by position, or with the single tag when only one is found
+func (i *Image) Images(object map[string]interface{}) ([]string, error) {
+	if len(i.Path) > 0 {
+		return find(object, i.Path)
+	}
+	repositories, err := find(object, i.Repository)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	if len(i.Tag) > 0 {
+		tags, err = find(object, i.Tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var images []string
+	for n, repository := range repositories {
+		switch {
+		case len(tags) == len(repositories):
+			images = append(images, joinTag(repository, tags[n]))
+		case len(tags) == 1:
+			images = append(images, joinTag(repository, tags[0]))
+		default:
+			images = append(images, repository)
+		}
+	}
+	return images, nil
+}
+
+func joinTag(repository string, tag string) string {
+	if strings.HasPrefix(tag, "sha256:") {
+		return repository + "@" + tag
+	}
+	return repository + ":" + tag
+}
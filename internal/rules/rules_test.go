@@ -0,0 +1,216 @@
+package rules
+
+import (
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	rules := &Rules{Rules: []Rule{
+		{APIVersion: "monitoring.coreos.com/*", Kind: "Prometheus"},
+		{APIVersion: "monitoring.coreos.com/v1", Kind: "Prometheus"},
+		{APIVersion: "example.com/v1", Kind: "App"},
+	}}
+	tests := []struct {
+		name       string
+		apiVersion string
+		kind       string
+		expected   []string
+	}{
+		{
+			name:       "wildcard and exact version",
+			apiVersion: "monitoring.coreos.com/v1",
+			kind:       "Prometheus",
+			expected:   []string{"monitoring.coreos.com/*", "monitoring.coreos.com/v1"},
+		},
+		{
+			name:       "wildcard only",
+			apiVersion: "monitoring.coreos.com/v1alpha1",
+			kind:       "Prometheus",
+			expected:   []string{"monitoring.coreos.com/*"},
+		},
+		{
+			name:       "wildcard is not a group prefix",
+			apiVersion: "monitoring.coreos.com.example/v1",
+			kind:       "Prometheus",
+		},
+		{
+			name:       "other kind",
+			apiVersion: "monitoring.coreos.com/v1",
+			kind:       "Alertmanager",
+		},
+		{
+			name:       "other version",
+			apiVersion: "example.com/v2",
+			kind:       "App",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var matched []string
+			for _, rule := range rules.Match(test.apiVersion, test.kind) {
+				matched = append(matched, rule.APIVersion)
+			}
+			if !reflect.DeepEqual(matched, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, matched)
+			}
+		})
+	}
+}
+
+func TestImages(t *testing.T) {
+	var object map[string]interface{}
+	err := yaml.Unmarshal([]byte(`
+spec:
+  image: quay.io/prometheus/prometheus:v2.45.0
+  baseImage: quay.io/prometheus/prometheus
+  version: v2.44.0
+  digest: sha256:0123456789abcdef
+  containers:
+    - name: a
+      image: busybox:1.36
+    - name: b
+      image: alpine:3.18
+    - name: c
+  sidecars:
+    - repository: envoy
+      tag: v1.26
+    - repository: nginx
+      tag: "1.25"
+`), &object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		image    Image
+		expected []string
+		err      string
+	}{
+		{
+			name:     "path",
+			image:    Image{Path: ".spec.image"},
+			expected: []string{"quay.io/prometheus/prometheus:v2.45.0"},
+		},
+		{
+			name:     "path without leading dot",
+			image:    Image{Path: "spec.image"},
+			expected: []string{"quay.io/prometheus/prometheus:v2.45.0"},
+		},
+		{
+			name:     "wildcard path skipping missing images",
+			image:    Image{Path: ".spec.containers[*].image"},
+			expected: []string{"busybox:1.36", "alpine:3.18"},
+		},
+		{
+			name:  "missing path",
+			image: Image{Path: ".spec.thanos.image"},
+		},
+		{
+			name:     "repository and tag",
+			image:    Image{Repository: ".spec.baseImage", Tag: ".spec.version"},
+			expected: []string{"quay.io/prometheus/prometheus:v2.44.0"},
+		},
+		{
+			name:     "repository and digest",
+			image:    Image{Repository: ".spec.baseImage", Tag: ".spec.digest"},
+			expected: []string{"quay.io/prometheus/prometheus@sha256:0123456789abcdef"},
+		},
+		{
+			name:     "repository without tag",
+			image:    Image{Repository: ".spec.baseImage", Tag: ".spec.missing"},
+			expected: []string{"quay.io/prometheus/prometheus"},
+		},
+		{
+			name:     "repositories joined with tags by position",
+			image:    Image{Repository: ".spec.sidecars[*].repository", Tag: ".spec.sidecars[*].tag"},
+			expected: []string{"envoy:v1.26", "nginx:1.25"},
+		},
+		{
+			name:     "repositories joined with the single tag",
+			image:    Image{Repository: ".spec.sidecars[*].repository", Tag: ".spec.version"},
+			expected: []string{"envoy:v2.44.0", "nginx:v2.44.0"},
+		},
+		{
+			name:  "invalid path",
+			image: Image{Path: "{.spec.containers[}"},
+			err:   "parsing path",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			images, err := test.image.Images(object)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(images, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, images)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name:    "valid rules",
+			content: "rules:\n- apiVersion: example.com/*\n  kind: App\n  images:\n  - path: .spec.image\n",
+		},
+		{
+			name:    "missing kind",
+			content: "rules:\n- apiVersion: example.com/v1\n",
+			err:     "rule 1 shall define apiVersion and kind",
+		},
+		{
+			name:    "image without path nor repository",
+			content: "rules:\n- apiVersion: example.com/v1\n  kind: App\n  images:\n  - tag: .spec.tag\n",
+			err:     "shall define either path or repository",
+		},
+		{
+			name:    "image with path and repository",
+			content: "rules:\n- apiVersion: example.com/v1\n  kind: App\n  images:\n  - path: .spec.image\n    repository: .spec.repository\n",
+			err:     "cannot define both path and repository/tag",
+		},
+		{
+			name:    "unknown field",
+			content: "rules:\n- apiVersion: example.com/v1\n  kind: App\n  image: .spec.image\n",
+			err:     "parsing test",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parse("test", []byte(test.content))
+			if len(test.err) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestBuiltinRules(t *testing.T) {
+	rules, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules.Match("monitoring.coreos.com/v1", "Prometheus")) == 0 {
+		t.Error("no built-in rule for Prometheus")
+	}
+}
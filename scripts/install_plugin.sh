@@ -7,6 +7,8 @@ echo "Downloading and installing image v${version}..."
 url=""
 if [ "$(uname)" = "Linux" ] ; then
     url="https://github.com/cvila84/helm-image/releases/download/v${version}/helm-image-linux-amd64.tar.gz"
+elif [ "$(uname)" = "Darwin" ] ; then
+    url="https://github.com/cvila84/helm-image/releases/download/v${version}/helm-image-darwin-amd64.tar.gz"
 else
     url="https://github.com/cvila84/helm-image/releases/download/v${version}/helm-image-windows-amd64.tar.gz"
 fi